@@ -0,0 +1,98 @@
+package accounts
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"goblockstore/parser"
+)
+
+// Bootstrapper collects the startup account dump a Geyser plugin emits when
+// the validator starts into a snapshot. The first regular update marks the
+// snapshot as complete, seeds the latest account state from it and hands
+// over to incremental ingestion.
+type Bootstrapper struct {
+	DB *sql.DB
+
+	snapshotSlot uint64
+	count        int64
+	started      bool
+	done         bool
+}
+
+// NewBootstrapper creates a bootstrapper writing to db
+func NewBootstrapper(db *sql.DB) *Bootstrapper {
+	return &Bootstrapper{DB: db}
+}
+
+// Done reports whether bootstrap has finished and updates are incremental
+func (b *Bootstrapper) Done() bool {
+	return b.done
+}
+
+// Handle consumes startup updates and reports whether account was one of
+// them. Regular updates are left to the caller once the snapshot is complete.
+func (b *Bootstrapper) Handle(account *parser.AccountUpdate, isStartup bool) (bool, error) {
+	if b.done {
+		return false, nil
+	}
+	if !isStartup {
+		return false, b.complete()
+	}
+
+	if !b.started {
+		b.snapshotSlot = account.Slot
+		b.started = true
+		if err := b.startRun(); err != nil {
+			return true, err
+		}
+		log.Printf("Collecting startup account snapshot at slot %d", b.snapshotSlot)
+	}
+
+	if err := parser.SaveAccountSnapshot(b.DB, b.snapshotSlot, account); err != nil {
+		return true, err
+	}
+	b.count++
+	return true, nil
+}
+
+// complete finishes the snapshot once the first regular update arrives
+func (b *Bootstrapper) complete() error {
+	if !b.started {
+		log.Println("No startup account updates received, continuing with incremental updates only")
+		b.done = true
+		return nil
+	}
+
+	seeded, err := parser.SeedLatestFromSnapshot(b.DB, b.snapshotSlot)
+	if err != nil {
+		return fmt.Errorf("error seeding latest accounts from snapshot %d: %v", b.snapshotSlot, err)
+	}
+
+	_, err = b.DB.Exec(`
+		UPDATE account_snapshot_runs
+		SET account_count = ?, completed_at = ?
+		WHERE snapshot_slot = ?`, b.count, time.Now(), b.snapshotSlot)
+	if err != nil {
+		return fmt.Errorf("error completing snapshot %d: %v", b.snapshotSlot, err)
+	}
+
+	log.Printf("Account snapshot at slot %d complete with %d accounts, switching to incremental updates", b.snapshotSlot, seeded)
+	b.done = true
+	return nil
+}
+
+// startRun records that a snapshot is being collected
+func (b *Bootstrapper) startRun() error {
+	_, err := b.DB.Exec(`
+		INSERT INTO account_snapshot_runs (snapshot_slot, account_count, started_at)
+		VALUES (?, 0, ?)
+		ON DUPLICATE KEY UPDATE started_at = VALUES(started_at), completed_at = NULL`,
+		b.snapshotSlot, time.Now())
+	if err != nil {
+		return fmt.Errorf("error starting snapshot %d: %v", b.snapshotSlot, err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package accounts
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	pb "goblockstore/proto"
+)
+
+// Memcmp matches accounts whose data at Offset equals the base58 encoded Bytes
+type Memcmp struct {
+	Offset uint64 `json:"offset"`
+	Base58 string `json:"base58"`
+}
+
+// Lamports matches accounts by balance. Op is one of eq, ne, lt or gt.
+type Lamports struct {
+	Op    string `json:"op"`
+	Value uint64 `json:"value"`
+}
+
+// Filter selects the accounts to subscribe to. Pubkeys and Owners are
+// alternatives, while Memcmp, DataSize, Lamports and TokenAccountState must
+// all match.
+type Filter struct {
+	Pubkeys  []string  `json:"pubkeys,omitempty"`
+	Owners   []string  `json:"owners,omitempty"`
+	Memcmp   []Memcmp  `json:"memcmp,omitempty"`
+	DataSize *uint64   `json:"datasize,omitempty"`
+	Lamports *Lamports `json:"lamports,omitempty"`
+	// TokenAccountState keeps only valid SPL token accounts
+	TokenAccountState bool `json:"token_account_state,omitempty"`
+}
+
+// FilterFromEnv builds a filter from the ACCOUNTS_* environment variables.
+// It returns nil when no account filter is configured.
+//
+//	ACCOUNTS_PUBKEYS   comma separated account pubkeys
+//	ACCOUNTS_OWNERS    comma separated owner program ids
+//	ACCOUNTS_MEMCMP    semicolon separated offset:base58 pairs
+//	ACCOUNTS_DATASIZE  exact account data length
+//	ACCOUNTS_LAMPORTS  op:value, e.g. gt:1000000
+func FilterFromEnv() (*Filter, error) {
+	filter := &Filter{
+		Pubkeys: splitList(os.Getenv("ACCOUNTS_PUBKEYS"), ","),
+		Owners:  splitList(os.Getenv("ACCOUNTS_OWNERS"), ","),
+	}
+
+	for _, pair := range splitList(os.Getenv("ACCOUNTS_MEMCMP"), ";") {
+		offset, data, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid ACCOUNTS_MEMCMP entry %q, expected offset:base58", pair)
+		}
+		n, err := strconv.ParseUint(offset, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCOUNTS_MEMCMP offset %q: %v", offset, err)
+		}
+		filter.Memcmp = append(filter.Memcmp, Memcmp{Offset: n, Base58: data})
+	}
+
+	if size := os.Getenv("ACCOUNTS_DATASIZE"); size != "" {
+		n, err := strconv.ParseUint(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCOUNTS_DATASIZE %q: %v", size, err)
+		}
+		filter.DataSize = &n
+	}
+
+	if lamports := os.Getenv("ACCOUNTS_LAMPORTS"); lamports != "" {
+		op, value, ok := strings.Cut(lamports, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid ACCOUNTS_LAMPORTS %q, expected op:value", lamports)
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCOUNTS_LAMPORTS value %q: %v", value, err)
+		}
+		filter.Lamports = &Lamports{Op: op, Value: n}
+	}
+
+	if filter.IsEmpty() {
+		return nil, nil
+	}
+	if _, err := filter.ToProto(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// IsEmpty reports whether the filter has no criteria
+func (f *Filter) IsEmpty() bool {
+	return len(f.Pubkeys) == 0 && len(f.Owners) == 0 && len(f.Memcmp) == 0 &&
+		f.DataSize == nil && f.Lamports == nil && !f.TokenAccountState
+}
+
+// ToProto converts the filter into a Geyser account subscription filter
+func (f *Filter) ToProto() (*pb.SubscribeRequestFilterAccounts, error) {
+	result := &pb.SubscribeRequestFilterAccounts{
+		Account: f.Pubkeys,
+		Owner:   f.Owners,
+	}
+
+	for _, m := range f.Memcmp {
+		result.Filters = append(result.Filters, &pb.SubscribeRequestFilterAccountsFilter{
+			Filter: &pb.SubscribeRequestFilterAccountsFilter_Memcmp{
+				Memcmp: &pb.SubscribeRequestFilterAccountsFilterMemcmp{
+					Offset: m.Offset,
+					Data:   &pb.SubscribeRequestFilterAccountsFilterMemcmp_Base58{Base58: m.Base58},
+				},
+			},
+		})
+	}
+
+	if f.DataSize != nil {
+		result.Filters = append(result.Filters, &pb.SubscribeRequestFilterAccountsFilter{
+			Filter: &pb.SubscribeRequestFilterAccountsFilter_Datasize{Datasize: *f.DataSize},
+		})
+	}
+
+	if f.Lamports != nil {
+		lamports := &pb.SubscribeRequestFilterAccountsFilterLamports{}
+		switch f.Lamports.Op {
+		case "eq":
+			lamports.Cmp = &pb.SubscribeRequestFilterAccountsFilterLamports_Eq{Eq: f.Lamports.Value}
+		case "ne":
+			lamports.Cmp = &pb.SubscribeRequestFilterAccountsFilterLamports_Ne{Ne: f.Lamports.Value}
+		case "lt":
+			lamports.Cmp = &pb.SubscribeRequestFilterAccountsFilterLamports_Lt{Lt: f.Lamports.Value}
+		case "gt":
+			lamports.Cmp = &pb.SubscribeRequestFilterAccountsFilterLamports_Gt{Gt: f.Lamports.Value}
+		default:
+			return nil, fmt.Errorf("unknown lamports comparison %q", f.Lamports.Op)
+		}
+		result.Filters = append(result.Filters, &pb.SubscribeRequestFilterAccountsFilter{
+			Filter: &pb.SubscribeRequestFilterAccountsFilter_Lamports{Lamports: lamports},
+		})
+	}
+
+	if f.TokenAccountState {
+		result.Filters = append(result.Filters, &pb.SubscribeRequestFilterAccountsFilter{
+			Filter: &pb.SubscribeRequestFilterAccountsFilter_TokenAccountState{TokenAccountState: true},
+		})
+	}
+
+	return result, nil
+}
+
+// splitList splits s on sep, dropping empty entries
+func splitList(s, sep string) []string {
+	var result []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
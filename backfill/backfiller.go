@@ -0,0 +1,176 @@
+package backfill
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"goblockstore/parser"
+)
+
+// Backfill defaults
+const (
+	DefaultWindow      = 50000
+	DefaultBatchSize   = 100
+	DefaultMaxAttempts = 5
+)
+
+// Backfiller periodically scans the blocks table for broken parent links and
+// fetches the missing blocks from a BlockSource
+type Backfiller struct {
+	DB          *sql.DB
+	Source      BlockSource
+	Interval    time.Duration
+	Window      uint64
+	BatchSize   int
+	MaxAttempts int
+
+	// scannedTo is the highest slot covered by a previous scan
+	scannedTo uint64
+	// floor is the first stored slot as of the last scan
+	floor uint64
+}
+
+// Run performs a backfill pass every Interval until ctx is cancelled
+func (b *Backfiller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.Pass(ctx); err != nil {
+			log.Printf("Backfill pass failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pass scans for new gaps and then works through the pending ones. A source
+// holding a connection is closed at the end of the pass.
+func (b *Backfiller) Pass(ctx context.Context) error {
+	if closer, ok := b.Source.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if err := b.scan(); err != nil {
+		return err
+	}
+
+	batchSize := b.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	gaps, err := PendingGaps(b.DB, batchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range gaps {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		b.fill(ctx, &gaps[i])
+		if err := updateGap(b.DB, &gaps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan detects gaps between the last scanned slot and the chain head, or
+// from the first stored slot on the first pass. The previous window is
+// rescanned so blocks that arrived late are rechecked.
+func (b *Backfiller) scan() error {
+	window := b.Window
+	if window == 0 {
+		window = DefaultWindow
+	}
+
+	head, ok, err := parser.LastSavedSlot(b.DB)
+	if err != nil || !ok {
+		return err
+	}
+	floor, ok, err := FirstStoredSlot(b.DB)
+	if err != nil || !ok {
+		return err
+	}
+	b.floor = floor
+
+	from := floor
+	if b.scannedTo > floor+window {
+		from = b.scannedTo - window
+	}
+	for start := from; start <= head; start += window {
+		gaps, err := detectGaps(b.DB, floor, start, start+window-1)
+		if err != nil {
+			return err
+		}
+		if err := RecordGaps(b.DB, gaps); err != nil {
+			return err
+		}
+	}
+	b.scannedTo = head
+	return nil
+}
+
+// fill fetches and saves every slot of a missed gap, recording the outcome on gap
+func (b *Backfiller) fill(ctx context.Context, gap *Gap) {
+	maxAttempts := b.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	gap.Attempts++
+	err := b.fillRange(ctx, gap.StartSlot, gap.EndSlot)
+	switch {
+	case err == nil:
+		gap.Status = StatusDone
+		gap.LastError = nil
+	case errors.Is(err, ErrSlotSkipped):
+		gap.Kind = GapSkipped
+		gap.Status = StatusDone
+		gap.LastError = nil
+	default:
+		msg := err.Error()
+		gap.LastError = &msg
+		if gap.Attempts >= maxAttempts {
+			gap.Status = StatusFailed
+		}
+		log.Printf("Failed to backfill slots %d-%d (attempt %d): %v", gap.StartSlot, gap.EndSlot, gap.Attempts, err)
+	}
+}
+
+// fillRange backfills each slot in [start, end] and checks the parent link of
+// every recovered block so the walk continues down the chain
+func (b *Backfiller) fillRange(ctx context.Context, start, end uint64) error {
+	for slot := start; slot <= end; slot++ {
+		block, err := b.Source.FetchBlock(ctx, slot)
+		if err != nil {
+			return err
+		}
+
+		parsedBlock, err := parser.ParseBlock(block)
+		if err != nil {
+			return fmt.Errorf("error parsing block %d: %v", slot, err)
+		}
+		if _, err := parser.SaveBlock(b.DB, parsedBlock, nil); err != nil {
+			return err
+		}
+		log.Printf("Backfilled block %d with %d transactions", slot, len(parsedBlock.Transactions))
+
+		gaps, err := detectGaps(b.DB, b.floor, slot, slot)
+		if err != nil {
+			return err
+		}
+		if err := RecordGaps(b.DB, gaps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package backfill
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Gap kinds
+const (
+	// GapMissed is a slot that has a block on the chain but none stored
+	GapMissed = "missed"
+	// GapSkipped is a slot range the leader never produced a block for
+	GapSkipped = "skipped"
+)
+
+// Gap statuses
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Gap represents a slot range that is not covered by the blocks table
+type Gap struct {
+	StartSlot  uint64    `db:"start_slot"`
+	EndSlot    uint64    `db:"end_slot"`
+	Kind       string    `db:"kind"`
+	Status     string    `db:"status"`
+	Attempts   int       `db:"attempts"`
+	LastError  *string   `db:"last_error"`
+	DetectedAt time.Time `db:"detected_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+// chainLink is the part of a stored block needed to verify the parent chain
+type chainLink struct {
+	slot              uint64
+	parentSlot        uint64
+	blockhash         string
+	previousBlockhash string
+}
+
+// DetectGaps walks the ParentSlot links of the live blocks in [from, to].
+// A parent that is not stored is reported as a missed slot, and the slots
+// strictly between a block and its parent are reported as skipped, since the
+// chain itself proves no block was produced there. Parents older than the
+// first stored block are ignored as the start of history.
+func DetectGaps(db *sql.DB, from, to uint64) ([]Gap, error) {
+	floor, ok, err := FirstStoredSlot(db)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return detectGaps(db, floor, from, to)
+}
+
+// FirstStoredSlot returns the lowest slot in the blocks table, where history
+// starts. The boolean is false when no block has been saved yet.
+func FirstStoredSlot(db *sql.DB) (uint64, bool, error) {
+	var floor sql.NullInt64
+	if err := db.QueryRow(`SELECT MIN(slot) FROM blocks WHERE deleted_at IS NULL`).Scan(&floor); err != nil {
+		return 0, false, fmt.Errorf("error querying first stored slot: %v", err)
+	}
+	if !floor.Valid {
+		return 0, false, nil
+	}
+	return uint64(floor.Int64), true, nil
+}
+
+// detectGaps is DetectGaps with history starting at floor
+func detectGaps(db *sql.DB, floor, from, to uint64) ([]Gap, error) {
+	rows, err := db.Query(`
+		SELECT slot, parent_slot, blockhash, previous_blockhash
+		FROM blocks
+		WHERE deleted_at IS NULL AND slot BETWEEN ? AND ?
+		ORDER BY slot`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error querying blocks: %v", err)
+	}
+	defer rows.Close()
+
+	var links []chainLink
+	hashes := make(map[uint64]string)
+	for rows.Next() {
+		var l chainLink
+		if err := rows.Scan(&l.slot, &l.parentSlot, &l.blockhash, &l.previousBlockhash); err != nil {
+			return nil, fmt.Errorf("error scanning block: %v", err)
+		}
+		links = append(links, l)
+		hashes[l.slot] = l.blockhash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading blocks: %v", err)
+	}
+
+	// Parents that fall before the window have to be looked up separately
+	var outside []uint64
+	for _, l := range links {
+		if l.parentSlot < from && l.parentSlot >= floor {
+			outside = append(outside, l.parentSlot)
+		}
+	}
+	if err := loadBlockhashes(db, outside, hashes); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var gaps []Gap
+	for _, l := range links {
+		if l.slot == floor || l.parentSlot < floor {
+			continue
+		}
+
+		if parentHash, ok := hashes[l.parentSlot]; !ok {
+			gaps = append(gaps, Gap{
+				StartSlot:  l.parentSlot,
+				EndSlot:    l.parentSlot,
+				Kind:       GapMissed,
+				Status:     StatusPending,
+				DetectedAt: now,
+				UpdatedAt:  now,
+			})
+		} else if parentHash != l.previousBlockhash {
+			log.Printf("Block %d links to parent hash %s but stored block %d has %s", l.slot, l.previousBlockhash, l.parentSlot, parentHash)
+		}
+
+		if l.slot > l.parentSlot+1 {
+			gaps = append(gaps, Gap{
+				StartSlot:  l.parentSlot + 1,
+				EndSlot:    l.slot - 1,
+				Kind:       GapSkipped,
+				Status:     StatusDone,
+				DetectedAt: now,
+				UpdatedAt:  now,
+			})
+		}
+	}
+
+	return gaps, nil
+}
+
+// loadBlockhashes adds the blockhash of every stored slot in slots to hashes
+func loadBlockhashes(db *sql.DB, slots []uint64, hashes map[uint64]string) error {
+	if len(slots) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(slots))
+	for i, slot := range slots {
+		args[i] = slot
+	}
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT slot, blockhash
+		FROM blocks
+		WHERE deleted_at IS NULL AND slot IN (%s)`,
+		strings.Repeat("?,", len(slots)-1)+"?"), args...)
+	if err != nil {
+		return fmt.Errorf("error querying parent blocks: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slot uint64
+		var hash string
+		if err := rows.Scan(&slot, &hash); err != nil {
+			return fmt.Errorf("error scanning parent block: %v", err)
+		}
+		hashes[slot] = hash
+	}
+	return rows.Err()
+}
+
+// RecordGaps queues detected gaps, ignoring ones that are already recorded
+func RecordGaps(db *sql.DB, gaps []Gap) error {
+	for _, gap := range gaps {
+		_, err := db.Exec(`
+			INSERT IGNORE INTO block_gaps (
+				start_slot, end_slot, kind, status, attempts, detected_at, updated_at
+			) VALUES (?, ?, ?, ?, 0, ?, ?)`,
+			gap.StartSlot, gap.EndSlot, gap.Kind, gap.Status, gap.DetectedAt, gap.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("error recording gap at slot %d: %v", gap.StartSlot, err)
+		}
+	}
+	return nil
+}
+
+// PendingGaps returns up to limit missed gaps still waiting for backfill
+func PendingGaps(db *sql.DB, limit int) ([]Gap, error) {
+	rows, err := db.Query(`
+		SELECT start_slot, end_slot, kind, status, attempts, last_error, detected_at, updated_at
+		FROM block_gaps
+		WHERE kind = ? AND status = ?
+		ORDER BY start_slot
+		LIMIT ?`, GapMissed, StatusPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying pending gaps: %v", err)
+	}
+	defer rows.Close()
+
+	var gaps []Gap
+	for rows.Next() {
+		var gap Gap
+		var lastError sql.NullString
+		if err := rows.Scan(&gap.StartSlot, &gap.EndSlot, &gap.Kind, &gap.Status, &gap.Attempts,
+			&lastError, &gap.DetectedAt, &gap.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning gap: %v", err)
+		}
+		if lastError.Valid {
+			gap.LastError = &lastError.String
+		}
+		gaps = append(gaps, gap)
+	}
+	return gaps, rows.Err()
+}
+
+// updateGap stores the outcome of a backfill attempt
+func updateGap(db *sql.DB, gap *Gap) error {
+	gap.UpdatedAt = time.Now()
+	_, err := db.Exec(`
+		UPDATE block_gaps
+		SET kind = ?, status = ?, attempts = ?, last_error = ?, updated_at = ?
+		WHERE start_slot = ?`,
+		gap.Kind, gap.Status, gap.Attempts, gap.LastError, gap.UpdatedAt, gap.StartSlot)
+	if err != nil {
+		return fmt.Errorf("error updating gap at slot %d: %v", gap.StartSlot, err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "goblockstore/proto"
+
+	"google.golang.org/grpc"
+)
+
+// ErrSlotSkipped is returned by a BlockSource when the requested slot has no block
+var ErrSlotSkipped = errors.New("slot was skipped")
+
+// BlockSource fetches historical blocks by slot for backfill
+type BlockSource interface {
+	FetchBlock(ctx context.Context, slot uint64) (*pb.SubscribeUpdateBlock, error)
+}
+
+// GeyserSource fetches blocks by replaying a Geyser subscription from the
+// requested slot. It only reaches as far back as the provider's replay window.
+// The connection is reused across fetches until Close.
+type GeyserSource struct {
+	Dial    func(ctx context.Context) (*grpc.ClientConn, error)
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn
+}
+
+// client returns a client on the shared connection, dialing it if needed
+func (g *GeyserSource) client(ctx context.Context) (pb.GeyserClient, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn == nil {
+		conn, err := g.Dial(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error dialing: %v", err)
+		}
+		g.conn = conn
+	}
+	return pb.NewGeyserClient(g.conn), nil
+}
+
+// Close closes the shared connection. The next fetch dials a new one.
+func (g *GeyserSource) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
+
+// FetchBlock replays the stream from slot and returns the block produced at it
+func (g *GeyserSource) FetchBlock(ctx context.Context, slot uint64) (*pb.SubscribeUpdateBlock, error) {
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client, err := g.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := client.Subscribe(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error subscribing: %v", err)
+	}
+
+	commitment := pb.CommitmentLevel_FINALIZED
+	includeEntries := true
+	if err := stream.Send(&pb.SubscribeRequest{
+		Commitment: &commitment,
+		FromSlot:   &slot,
+		Blocks: map[string]*pb.SubscribeRequestFilterBlocks{
+			"backfill": {IncludeEntries: &includeEntries},
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("error sending subscription request: %v", err)
+	}
+
+	for {
+		m, err := stream.Recv()
+		if err != nil {
+			return nil, fmt.Errorf("error replaying slot %d: %v", slot, err)
+		}
+		block := m.GetBlock()
+		if block == nil || block.Slot < slot {
+			continue
+		}
+		if block.Slot == slot {
+			return block, nil
+		}
+		// A later block whose parent precedes slot proves slot was skipped
+		if block.ParentSlot < slot {
+			return nil, ErrSlotSkipped
+		}
+		return nil, fmt.Errorf("slot %d was not replayed, stream resumed at %d", slot, block.Slot)
+	}
+}
@@ -0,0 +1,46 @@
+package connect
+
+import (
+	"context"
+	"encoding/base64"
+)
+
+// headerAuth sends a metadata header carrying the current token with every call
+type headerAuth struct {
+	key    string
+	prefix string
+	token  func(ctx context.Context) (string, error)
+	secure bool
+}
+
+func (h headerAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := h.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{h.key: h.prefix + token}, nil
+}
+
+func (h headerAuth) RequireTransportSecurity() bool {
+	return h.secure
+}
+
+// basicAuth sends HTTP basic credentials with every call
+type basicAuth struct {
+	username string
+	password func(ctx context.Context) (string, error)
+	secure   bool
+}
+
+func (b basicAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	password, err := b.password(ctx)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(b.username + ":" + password))
+	return map[string]string{"authorization": "Basic " + encoded}, nil
+}
+
+func (b basicAuth) RequireTransportSecurity() bool {
+	return b.secure
+}
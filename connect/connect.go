@@ -0,0 +1,266 @@
+package connect
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Auth modes for Config.Auth
+const (
+	AuthNone   = "none"
+	AuthXToken = "x-token"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthHeader = "header"
+)
+
+// Compression settings for Config.Compression
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// DefaultMaxMessageSize bounds received messages; full blocks can be large
+const DefaultMaxMessageSize = 1024 * 1024 * 1024
+
+// DefaultKeepalive pings idle connections so dead peers are noticed
+var DefaultKeepalive = keepalive.ClientParameters{
+	Time:                10 * time.Second,
+	Timeout:             time.Second,
+	PermitWithoutStream: true,
+}
+
+// Config describes how to reach a Geyser gRPC server of any provider
+type Config struct {
+	// Target is a full URL. https:// (or a bare host:port) uses TLS and
+	// http:// connects in plaintext, e.g. for a local test server.
+	Target string
+
+	// Auth is one of the Auth* modes. It defaults to x-token when Token is
+	// set and to none otherwise.
+	Auth string
+	// Token is the x-token, the bearer token or the custom header value
+	Token string
+	// Credentials, when set, replaces Token with a rotating one
+	Credentials *Rotating
+	// Username and Password are used by basic auth
+	Username string
+	Password string
+	// Header names the metadata key sent by the header auth mode
+	Header string
+
+	// CAFile verifies the server against a custom CA bundle
+	CAFile string
+	// CertFile and KeyFile present a client certificate for mTLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name checked against the server certificate
+	ServerName string
+
+	// Keepalive defaults to DefaultKeepalive when zero
+	Keepalive keepalive.ClientParameters
+	// Compression is gzip (the default) or none
+	Compression string
+	// MaxMessageSize defaults to DefaultMaxMessageSize when zero
+	MaxMessageSize int
+}
+
+// Dial creates a gRPC client as described by the config. The connection is
+// established lazily, so endpoint failures surface on the first RPC and are
+// retried by the caller's backoff.
+func (c *Config) Dial(ctx context.Context) (*grpc.ClientConn, error) {
+	target, opts, err := c.DialOptions()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %v", target, err)
+	}
+	return conn, nil
+}
+
+// DialOptions resolves the dial target and options without connecting
+func (c *Config) DialOptions() (string, []grpc.DialOption, error) {
+	target, plaintext, err := parseTarget(c.Target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var opts []grpc.DialOption
+	if plaintext {
+		if c.CAFile != "" || c.CertFile != "" {
+			return "", nil, fmt.Errorf("TLS files given for plaintext target %s", c.Target)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return "", nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+
+	auth, err := c.credentials(!plaintext)
+	if err != nil {
+		return "", nil, err
+	}
+	if auth != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth))
+	}
+
+	params := c.Keepalive
+	if params == (keepalive.ClientParameters{}) {
+		params = DefaultKeepalive
+	}
+	opts = append(opts, grpc.WithKeepaliveParams(params))
+
+	maxSize := c.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	callOpts := []grpc.CallOption{grpc.MaxCallRecvMsgSize(maxSize)}
+	switch c.Compression {
+	case "", CompressionGzip:
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	case CompressionNone:
+	default:
+		return "", nil, fmt.Errorf("unknown compression %q", c.Compression)
+	}
+	opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+
+	return target, opts, nil
+}
+
+// tlsConfig builds the client TLS settings, loading a custom CA and a client
+// certificate when configured
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: c.ServerName}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("mTLS needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// credentials returns the per-RPC credentials for the auth mode, or nil
+func (c *Config) credentials(secure bool) (credentials.PerRPCCredentials, error) {
+	mode := c.Auth
+	if mode == "" {
+		mode = AuthNone
+		if c.Token != "" || c.Credentials != nil {
+			mode = AuthXToken
+		}
+	}
+
+	token := c.staticToken
+	if c.Credentials != nil {
+		token = c.Credentials.Token
+	}
+
+	switch mode {
+	case AuthNone:
+		return nil, nil
+	case AuthXToken:
+		return headerAuth{key: "x-token", token: token, secure: secure}, c.requireToken()
+	case AuthBearer:
+		return headerAuth{key: "authorization", prefix: "Bearer ", token: token, secure: secure}, c.requireToken()
+	case AuthHeader:
+		if c.Header == "" {
+			return nil, fmt.Errorf("header auth needs a header name")
+		}
+		return headerAuth{key: strings.ToLower(c.Header), token: token, secure: secure}, c.requireToken()
+	case AuthBasic:
+		if c.Username == "" {
+			return nil, fmt.Errorf("basic auth needs a username")
+		}
+		password := token
+		if c.Password != "" {
+			password = func(context.Context) (string, error) { return c.Password, nil }
+		}
+		return basicAuth{username: c.Username, password: password, secure: secure}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", mode)
+	}
+}
+
+// staticToken returns the fixed Token
+func (c *Config) staticToken(context.Context) (string, error) {
+	return c.Token, nil
+}
+
+// requireToken reports a missing token
+func (c *Config) requireToken() error {
+	if c.Token == "" && c.Credentials == nil {
+		return fmt.Errorf("%s auth needs a token", c.Auth)
+	}
+	return nil
+}
+
+// parseTarget turns a URL into a gRPC dial target and reports whether the
+// connection is plaintext
+func parseTarget(raw string) (string, bool, error) {
+	if raw == "" {
+		return "", false, fmt.Errorf("no target configured")
+	}
+	if !strings.Contains(raw, "://") {
+		// A bare host:port, as QuickNode documents its endpoints
+		return raw, false, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid target %q: %v", raw, err)
+	}
+	if u.Host == "" {
+		return "", false, fmt.Errorf("invalid target %q: missing host", raw)
+	}
+
+	var plaintext bool
+	var port string
+	switch u.Scheme {
+	case "https", "grpcs":
+		port = "443"
+	case "http", "grpc":
+		plaintext, port = true, "80"
+	default:
+		return "", false, fmt.Errorf("unsupported target scheme %q", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), plaintext, nil
+}
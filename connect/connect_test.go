@@ -0,0 +1,68 @@
+package connect
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/keepalive"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		raw       string
+		target    string
+		plaintext bool
+		wantErr   bool
+	}{
+		{"grpc.example.com:10000", "grpc.example.com:10000", false, false},
+		{"https://grpc.example.com", "grpc.example.com:443", false, false},
+		{"https://grpc.example.com:2053/", "grpc.example.com:2053", false, false},
+		{"grpcs://grpc.example.com", "grpc.example.com:443", false, false},
+		{"http://localhost", "localhost:80", true, false},
+		{"grpc://127.0.0.1:10000", "127.0.0.1:10000", true, false},
+		{"http://[::1]:10000", "[::1]:10000", true, false},
+		{"", "", false, true},
+		{"https://", "", false, true},
+		{"ws://grpc.example.com", "", false, true},
+		{"https://grpc example.com", "", false, true},
+	}
+	for _, tt := range tests {
+		target, plaintext, err := parseTarget(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTarget(%q): error %v, want error %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if target != tt.target || plaintext != tt.plaintext {
+			t.Errorf("parseTarget(%q) = %q %v, want %q %v", tt.raw, target, plaintext, tt.target, tt.plaintext)
+		}
+	}
+}
+
+func TestFromEnvKeepalive(t *testing.T) {
+	tests := []struct {
+		name    string
+		time    string
+		timeout string
+		want    keepalive.ClientParameters
+		wantErr bool
+	}{
+		{"unset", "", "", keepalive.ClientParameters{}, false},
+		{"both", "30s", "5s", keepalive.ClientParameters{Time: 30 * time.Second, Timeout: 5 * time.Second, PermitWithoutStream: true}, false},
+		{"time only", "30s", "", keepalive.ClientParameters{Time: 30 * time.Second, Timeout: DefaultKeepalive.Timeout, PermitWithoutStream: true}, false},
+		{"timeout only", "", "5s", keepalive.ClientParameters{Time: DefaultKeepalive.Time, Timeout: 5 * time.Second, PermitWithoutStream: true}, false},
+		{"bad duration", "30", "", keepalive.ClientParameters{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GEYSER_KEEPALIVE_TIME", tt.time)
+			t.Setenv("GEYSER_KEEPALIVE_TIMEOUT", tt.timeout)
+			config, err := FromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromEnv: error %v, want error %v", err, tt.wantErr)
+			}
+			if config.Keepalive != tt.want {
+				t.Errorf("keepalive %+v, want %+v", config.Keepalive, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package connect
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// FromEnv reads the connection settings shared by every endpoint. Target
+// and Token are left for the caller to fill in per endpoint.
+//
+//	GEYSER_AUTH               none, x-token, basic, bearer or header
+//	GEYSER_USERNAME           basic auth user
+//	GEYSER_PASSWORD           basic auth password, defaults to the token
+//	GEYSER_AUTH_HEADER        metadata key for header auth
+//	GEYSER_CA_FILE            PEM bundle to verify the server with
+//	GEYSER_CERT_FILE          client certificate for mTLS
+//	GEYSER_KEY_FILE           client key for mTLS
+//	GEYSER_SERVER_NAME        overrides the TLS server name
+//	GEYSER_COMPRESSION        gzip or none
+//	GEYSER_MAX_MESSAGE_SIZE   largest message received, in bytes
+//	GEYSER_KEEPALIVE_TIME     how long a connection may idle before it is pinged, e.g. 10s
+//	GEYSER_KEEPALIVE_TIMEOUT  how long to wait for the ping to be answered, e.g. 1s
+//	GEYSER_TOKEN_FILE         reads a rotating token from this file, single endpoint only
+//	GEYSER_TOKEN_COMMAND      reads a rotating token from this command's output, single endpoint only
+//	GEYSER_TOKEN_REFRESH      how often to re-read a rotating token, e.g. 30s
+func FromEnv() (Config, error) {
+	config := Config{
+		Auth:        os.Getenv("GEYSER_AUTH"),
+		Username:    os.Getenv("GEYSER_USERNAME"),
+		Password:    os.Getenv("GEYSER_PASSWORD"),
+		Header:      os.Getenv("GEYSER_AUTH_HEADER"),
+		CAFile:      os.Getenv("GEYSER_CA_FILE"),
+		CertFile:    os.Getenv("GEYSER_CERT_FILE"),
+		KeyFile:     os.Getenv("GEYSER_KEY_FILE"),
+		ServerName:  os.Getenv("GEYSER_SERVER_NAME"),
+		Compression: os.Getenv("GEYSER_COMPRESSION"),
+	}
+
+	if size := os.Getenv("GEYSER_MAX_MESSAGE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid GEYSER_MAX_MESSAGE_SIZE %q: %v", size, err)
+		}
+		config.MaxMessageSize = n
+	}
+
+	for name, setting := range map[string]*time.Duration{
+		"GEYSER_KEEPALIVE_TIME":    &config.Keepalive.Time,
+		"GEYSER_KEEPALIVE_TIMEOUT": &config.Keepalive.Timeout,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid %s %q: %v", name, value, err)
+			}
+			*setting = d
+		}
+	}
+	// Whatever is left unset keeps its default
+	if config.Keepalive.Time != 0 || config.Keepalive.Timeout != 0 {
+		if config.Keepalive.Time == 0 {
+			config.Keepalive.Time = DefaultKeepalive.Time
+		}
+		if config.Keepalive.Timeout == 0 {
+			config.Keepalive.Timeout = DefaultKeepalive.Timeout
+		}
+		config.Keepalive.PermitWithoutStream = DefaultKeepalive.PermitWithoutStream
+	}
+
+	var source TokenSource
+	switch file, command := os.Getenv("GEYSER_TOKEN_FILE"), os.Getenv("GEYSER_TOKEN_COMMAND"); {
+	case file != "" && command != "":
+		return Config{}, fmt.Errorf("GEYSER_TOKEN_FILE and GEYSER_TOKEN_COMMAND are mutually exclusive")
+	case file != "":
+		source = &FileSource{Path: file}
+	case command != "":
+		source = &CommandSource{Command: command}
+	}
+	if source != nil {
+		var interval time.Duration
+		if refresh := os.Getenv("GEYSER_TOKEN_REFRESH"); refresh != "" {
+			var err error
+			interval, err = time.ParseDuration(refresh)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid GEYSER_TOKEN_REFRESH %q: %v", refresh, err)
+			}
+		}
+		config.Credentials = NewRotating(source, interval)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,186 @@
+package connect
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default refresh settings for rotating credentials
+const (
+	DefaultRefreshInterval = 30 * time.Second
+	DefaultRefreshBefore   = time.Minute
+)
+
+// TokenSource produces the current auth token
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// FileSource reads the token from a file, e.g. a mounted secret
+type FileSource struct {
+	Path string
+}
+
+// Token returns the trimmed file content
+func (f *FileSource) Token(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("error reading token file: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// CommandSource runs a shell command and uses its output as the token
+type CommandSource struct {
+	Command string
+}
+
+// Token runs the command and returns its trimmed standard output
+func (c *CommandSource) Token(ctx context.Context) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running token command: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Rotating caches the token of a source and refreshes it periodically. JWTs
+// are additionally refreshed RefreshBefore their expiry. Every change of the
+// token closes the channel returned by Changed, so long-lived streams that
+// authenticated with the old token can be re-established.
+type Rotating struct {
+	Source        TokenSource
+	Interval      time.Duration
+	RefreshBefore time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expiry  time.Time
+	changed chan struct{}
+}
+
+// NewRotating creates rotating credentials refreshed every interval
+func NewRotating(source TokenSource, interval time.Duration) *Rotating {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	return &Rotating{
+		Source:        source,
+		Interval:      interval,
+		RefreshBefore: DefaultRefreshBefore,
+		changed:       make(chan struct{}),
+	}
+}
+
+// Token returns the current token, fetching it when none is cached or a JWT
+// is about to expire
+func (r *Rotating) Token(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	token, stale := r.token, r.stale()
+	r.mu.Unlock()
+
+	if token != "" && !stale {
+		return token, nil
+	}
+	return r.refresh(ctx)
+}
+
+// Changed returns a channel closed the next time the token changes
+func (r *Rotating) Changed() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changed
+}
+
+// Run refreshes the token until ctx is cancelled
+func (r *Rotating) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.nextRefresh()):
+		}
+		if _, err := r.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh credentials: %v", err)
+		}
+	}
+}
+
+// refresh fetches a token from the source and signals a change
+func (r *Rotating) refresh(ctx context.Context) (string, error) {
+	token, err := r.Source.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("credential source returned an empty token")
+	}
+	expiry, _ := jwtExpiry(token)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if token != r.token {
+		if r.token != "" {
+			log.Printf("Credentials rotated")
+			close(r.changed)
+			r.changed = make(chan struct{})
+		}
+		r.token = token
+	}
+	r.expiry = expiry
+	return token, nil
+}
+
+// nextRefresh returns how long to wait before the next refresh
+func (r *Rotating) nextRefresh() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wait := r.Interval
+	if !r.expiry.IsZero() {
+		if untilRefresh := time.Until(r.expiry) - r.RefreshBefore; untilRefresh < wait {
+			wait = untilRefresh
+		}
+	}
+	// Avoid spinning on a source that keeps handing out expiring tokens
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// stale reports whether a cached JWT is due for refresh. r.mu must be held.
+func (r *Rotating) stale() bool {
+	return !r.expiry.IsZero() && time.Until(r.expiry) < r.RefreshBefore
+}
+
+// jwtExpiry reads the exp claim of a JWT without verifying it
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,112 @@
+package connect
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testJWT returns an unsigned JWT carrying claims
+func testJWT(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + ".sig"
+}
+
+// staticSource hands out a fixed token
+type staticSource struct {
+	token string
+	calls int
+}
+
+func (s *staticSource) Token(context.Context) (string, error) {
+	s.calls++
+	return s.token, nil
+}
+
+func TestJWTExpiry(t *testing.T) {
+	padded := base64.URLEncoding.EncodeToString([]byte(`{"exp":1700000000}`))
+	tests := []struct {
+		name   string
+		token  string
+		expiry int64 // 0 when no expiry is read
+	}{
+		{"exp claim", testJWT(`{"exp":1700000000}`), 1700000000},
+		{"padded payload", "e30." + padded + ".sig", 1700000000},
+		{"no exp claim", testJWT(`{"sub":"me"}`), 0},
+		{"zero exp", testJWT(`{"exp":0}`), 0},
+		{"not json", testJWT(`exp`), 0},
+		{"bad base64", "a.!!!.c", 0},
+		{"opaque token", "d3adb33f", 0},
+		{"two parts", "a.b", 0},
+	}
+	for _, tt := range tests {
+		expiry, ok := jwtExpiry(tt.token)
+		if ok != (tt.expiry != 0) {
+			t.Errorf("%s: ok %v, want %v", tt.name, ok, tt.expiry != 0)
+			continue
+		}
+		if ok && expiry.Unix() != tt.expiry {
+			t.Errorf("%s: expiry %d, want %d", tt.name, expiry.Unix(), tt.expiry)
+		}
+	}
+}
+
+func TestRotatingRefreshesExpiringJWT(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		calls int
+	}{
+		{"opaque token cached", "d3adb33f", 1},
+		{"distant expiry cached", testJWT(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(time.Hour).Unix())), 1},
+		{"near expiry refetched", testJWT(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(time.Second).Unix())), 2},
+	}
+	for _, tt := range tests {
+		source := &staticSource{token: tt.token}
+		r := NewRotating(source, time.Minute)
+		for i := 0; i < 2; i++ {
+			token, err := r.Token(context.Background())
+			if err != nil || token != tt.token {
+				t.Fatalf("%s: Token = %q, %v", tt.name, token, err)
+			}
+		}
+		if source.calls != tt.calls {
+			t.Errorf("%s: %d fetches, want %d", tt.name, source.calls, tt.calls)
+		}
+	}
+}
+
+func TestRotatingSignalsChange(t *testing.T) {
+	source := &staticSource{token: "first"}
+	r := NewRotating(source, time.Minute)
+	if _, err := r.Token(context.Background()); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	changed := r.Changed()
+
+	if _, err := r.refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	select {
+	case <-changed:
+		t.Fatal("Changed closed without a new token")
+	default:
+	}
+
+	source.token = "second"
+	if _, err := r.refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("Changed not closed after rotation")
+	}
+
+	source.token = ""
+	if _, err := r.refresh(context.Background()); err == nil {
+		t.Error("refresh accepted an empty token")
+	}
+}
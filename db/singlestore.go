@@ -35,8 +35,31 @@ type Index struct {
 	IndexType string   `json:"index_type"`
 }
 
-// Connect establishes a connection to SingleStore
+// Connect establishes a connection to SingleStore and creates the tables,
+// columns and procedures the indexer needs
 func Connect() (*sql.DB, error) {
+	db, err := Open()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the tables owned by the indexer itself
+	if err = initTables(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing tables: %v", err)
+	}
+
+	// Initialize stored procedures
+	if err = initStoredProcedures(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing stored procedures: %v", err)
+	}
+
+	return db, nil
+}
+
+// Open connects to SingleStore without touching the schema
+func Open() (*sql.DB, error) {
 	err := godotenv.Load()
 	if err != nil {
 		return nil, fmt.Errorf("error loading .env file: %v", err)
@@ -71,13 +94,272 @@ func Connect() (*sql.DB, error) {
 		return nil, fmt.Errorf("error pinging SingleStore: %v", err)
 	}
 
-	// Initialize stored procedures
-	if err = initStoredProcedures(db); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("error initializing stored procedures: %v", err)
+	return db, nil
+}
+
+// initTables creates the bookkeeping tables used by the ingestion pipelines
+func initTables(db *sql.DB) error {
+	tables := []string{
+		`CREATE TABLE IF NOT EXISTS ingest_checkpoints (
+			pipeline VARCHAR(64) NOT NULL,
+			last_slot BIGINT UNSIGNED NOT NULL,
+			blockhash VARCHAR(64) NOT NULL,
+			commitment VARCHAR(16) NOT NULL,
+			updated_at TIMESTAMP(6) NOT NULL,
+			PRIMARY KEY (pipeline)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS block_gaps (
+			start_slot BIGINT UNSIGNED NOT NULL,
+			end_slot BIGINT UNSIGNED NOT NULL,
+			kind VARCHAR(16) NOT NULL,
+			status VARCHAR(16) NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			detected_at TIMESTAMP(6) NOT NULL,
+			updated_at TIMESTAMP(6) NOT NULL,
+			PRIMARY KEY (start_slot)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS slot_status (
+			slot BIGINT UNSIGNED NOT NULL,
+			parent_slot BIGINT UNSIGNED,
+			processed_at TIMESTAMP(6) NULL,
+			confirmed_at TIMESTAMP(6) NULL,
+			finalized_at TIMESTAMP(6) NULL,
+			dead_at TIMESTAMP(6) NULL,
+			dead_error TEXT,
+			updated_at TIMESTAMP(6) NOT NULL,
+			created_at TIMESTAMP(6) NOT NULL,
+			PRIMARY KEY (slot)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS block_entries (
+			slot BIGINT UNSIGNED NOT NULL,
+			entry_index BIGINT UNSIGNED NOT NULL,
+			num_hashes BIGINT UNSIGNED NOT NULL,
+			hash VARCHAR(64) NOT NULL,
+			executed_transaction_count BIGINT UNSIGNED NOT NULL,
+			starting_transaction_index BIGINT UNSIGNED NOT NULL,
+			updated_at TIMESTAMP(6) NOT NULL,
+			created_at TIMESTAMP(6) NOT NULL,
+			deleted_at TIMESTAMP(6) NULL,
+			PRIMARY KEY (slot, entry_index)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS transaction_instruction_accounts (
+			slot BIGINT UNSIGNED NOT NULL,
+			transaction_index INT NOT NULL,
+			instruction_path VARCHAR(32) NOT NULL,
+			position INT NOT NULL,
+			program_id VARCHAR(64) NOT NULL,
+			pubkey VARCHAR(64) NOT NULL,
+			is_signer BOOLEAN NOT NULL,
+			is_writable BOOLEAN NOT NULL,
+			updated_at TIMESTAMP(6) NOT NULL,
+			created_at TIMESTAMP(6) NOT NULL,
+			deleted_at TIMESTAMP(6) NULL,
+			PRIMARY KEY (slot, transaction_index, instruction_path, position),
+			KEY (pubkey),
+			KEY (program_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS transaction_rewards (
+			slot BIGINT UNSIGNED NOT NULL,
+			transaction_index INT NOT NULL,
+			reward_index INT NOT NULL,
+			pubkey VARCHAR(64) NOT NULL,
+			lamports BIGINT NOT NULL,
+			post_balance BIGINT UNSIGNED NOT NULL,
+			reward_type VARCHAR(16) NOT NULL,
+			commission TINYINT UNSIGNED NULL,
+			updated_at TIMESTAMP(6) NOT NULL,
+			created_at TIMESTAMP(6) NOT NULL,
+			deleted_at TIMESTAMP(6) NULL,
+			PRIMARY KEY (slot, transaction_index, reward_index),
+			KEY (pubkey)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS account_updates (
+			slot BIGINT UNSIGNED NOT NULL,
+			pubkey VARCHAR(64) NOT NULL,
+			owner VARCHAR(64) NOT NULL,
+			lamports BIGINT UNSIGNED NOT NULL,
+			data LONGBLOB,
+			executable BOOLEAN NOT NULL,
+			rent_epoch BIGINT UNSIGNED NOT NULL,
+			write_version BIGINT UNSIGNED NOT NULL,
+			txn_signature VARCHAR(128),
+			updated_at TIMESTAMP(6) NOT NULL,
+			created_at TIMESTAMP(6) NOT NULL,
+			PRIMARY KEY (pubkey, slot, write_version)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS accounts_latest (
+			pubkey VARCHAR(64) NOT NULL,
+			slot BIGINT UNSIGNED NOT NULL,
+			owner VARCHAR(64) NOT NULL,
+			lamports BIGINT UNSIGNED NOT NULL,
+			data LONGBLOB,
+			executable BOOLEAN NOT NULL,
+			rent_epoch BIGINT UNSIGNED NOT NULL,
+			write_version BIGINT UNSIGNED NOT NULL,
+			txn_signature VARCHAR(128),
+			updated_at TIMESTAMP(6) NOT NULL,
+			created_at TIMESTAMP(6) NOT NULL,
+			PRIMARY KEY (pubkey)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS account_snapshots (
+			snapshot_slot BIGINT UNSIGNED NOT NULL,
+			pubkey VARCHAR(64) NOT NULL,
+			owner VARCHAR(64) NOT NULL,
+			lamports BIGINT UNSIGNED NOT NULL,
+			data LONGBLOB,
+			executable BOOLEAN NOT NULL,
+			rent_epoch BIGINT UNSIGNED NOT NULL,
+			write_version BIGINT UNSIGNED NOT NULL,
+			updated_at TIMESTAMP(6) NOT NULL,
+			created_at TIMESTAMP(6) NOT NULL,
+			PRIMARY KEY (snapshot_slot, pubkey)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS account_snapshot_runs (
+			snapshot_slot BIGINT UNSIGNED NOT NULL,
+			account_count BIGINT NOT NULL,
+			started_at TIMESTAMP(6) NOT NULL,
+			completed_at TIMESTAMP(6) NULL,
+			PRIMARY KEY (snapshot_slot)
+		)`,
 	}
 
-	return db, nil
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			return fmt.Errorf("error creating table: %v", err)
+		}
+	}
+	return initColumns(db)
+}
+
+// columnMigration adds a column to a table that predates it
+type columnMigration struct {
+	table      string
+	column     string
+	definition string
+}
+
+// columnMigrations lists the columns the indexer writes to pre-existing tables
+var columnMigrations = []columnMigration{
+	{"blocks", "executed_transaction_count", "BIGINT UNSIGNED NOT NULL DEFAULT 0"},
+	{"blocks", "entries_count", "BIGINT UNSIGNED NOT NULL DEFAULT 0"},
+	{"transactions", "entry_index", "BIGINT NULL"},
+	{"transaction_accounts", "lookup_table", "TEXT NULL"},
+	{"transaction_inner_instructions", "stack_height", "BIGINT NULL"},
+	{"blocks", "num_partitions", "BIGINT UNSIGNED NULL"},
+}
+
+// initColumns adds the columns the indexer writes to pre-existing tables
+func initColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*)
+			FROM INFORMATION_SCHEMA.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+			m.table, m.column).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("error checking column %s.%s: %v", m.table, m.column, err)
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %v", m.table, m.column, err)
+		}
+	}
+	return initNullable(db)
+}
+
+// nullableColumns lists pre-existing columns the indexer may leave NULL, as
+// table and column names
+var nullableColumns = [][2]string{
+	// Streamed transactions have no block time until their block arrives
+	{"transactions", "block_time"},
+}
+
+// initNullable drops NOT NULL from the columns in nullableColumns
+func initNullable(db *sql.DB) error {
+	for _, c := range nullableColumns {
+		var columnType, nullable string
+		err := db.QueryRow(`
+			SELECT COLUMN_TYPE, IS_NULLABLE
+			FROM INFORMATION_SCHEMA.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+			c[0], c[1]).Scan(&columnType, &nullable)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error checking column %s.%s: %v", c[0], c[1], err)
+		}
+		if nullable == "YES" {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s NULL", c[0], c[1], columnType)); err != nil {
+			return fmt.Errorf("error making column %s.%s nullable: %v", c[0], c[1], err)
+		}
+	}
+	return nil
+}
+
+// requiredTables lists every table the indexer reads or writes
+var requiredTables = []string{
+	"blocks", "block_rewards", "block_entries",
+	"transactions", "transactions_signatures", "transaction_instructions",
+	"transaction_inner_instructions", "transaction_logs", "transaction_accounts",
+	"transaction_token_balances", "transaction_instruction_accounts", "transaction_rewards",
+	"ingest_checkpoints", "block_gaps", "slot_status",
+	"account_updates", "accounts_latest", "account_snapshots", "account_snapshot_runs",
+}
+
+// CheckSchema reports the required tables and columns missing from the
+// database, as table or table.column names
+func CheckSchema(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT TABLE_NAME, COLUMN_NAME
+		FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE()`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema: %v", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, fmt.Errorf("error scanning schema: %v", err)
+		}
+		tables[table] = true
+		columns[table+"."+column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading schema: %v", err)
+	}
+
+	var missing []string
+	for _, table := range requiredTables {
+		if !tables[table] {
+			missing = append(missing, table)
+		}
+	}
+	for _, m := range columnMigrations {
+		if tables[m.table] && !columns[m.table+"."+m.column] {
+			missing = append(missing, m.table+"."+m.column)
+		}
+	}
+	return missing, nil
 }
 
 // initStoredProcedures creates the stored procedures for batch inserts
@@ -128,7 +410,8 @@ func initStoredProcedures(db *sql.DB) error {
 
 		`CREATE OR REPLACE PROCEDURE InsertTransactionInnerInstructions(innerInstructions ARRAY(RECORD(
 			slot BIGINT, slot_index INT, instruction_index INT, inner_instruction_index INT,
-			program_id TEXT, program_id_index INT, data TEXT, updated_at TIMESTAMP, created_at TIMESTAMP
+			program_id TEXT, program_id_index INT, stack_height BIGINT, data TEXT,
+			updated_at TIMESTAMP, created_at TIMESTAMP
 		))) AS
 		DECLARE
 			x INT;
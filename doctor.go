@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"goblockstore/connect"
+	"goblockstore/db"
+	pb "goblockstore/proto"
+)
+
+// doctorCommitments are the commitment levels the unary RPCs are checked at
+var doctorCommitments = []pb.CommitmentLevel{
+	pb.CommitmentLevel_PROCESSED,
+	pb.CommitmentLevel_CONFIRMED,
+	pb.CommitmentLevel_FINALIZED,
+}
+
+// doctorUpdates is how many Subscribe messages the latency check waits for
+const doctorUpdates = 10
+
+// checkResult is the outcome of a single diagnostic
+type checkResult struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// doctorReport collects the checks of one run
+type doctorReport struct {
+	OK     bool          `json:"ok"`
+	Checks []checkResult `json:"checks"`
+}
+
+// run times check and records its outcome under name
+func (r *doctorReport) run(name string, check func() (string, error)) error {
+	start := time.Now()
+	detail, err := check()
+	result := checkResult{
+		Name:       name,
+		OK:         err == nil,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:     detail,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		r.OK = false
+	}
+	r.Checks = append(r.Checks, result)
+	return err
+}
+
+// doctor checks every configured endpoint and the database, prints a report
+// and returns the process exit code
+func doctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each check")
+	flags.Parse(args)
+
+	report := &doctorReport{OK: true}
+
+	_, connections, err := loadConnections()
+	report.run("config", func() (string, error) {
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d endpoint(s)", len(connections)), nil
+	})
+	for i := range connections {
+		checkEndpoint(report, &connections[i], *timeout)
+	}
+	checkDatabase(report)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printReport(report)
+	}
+
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+// checkEndpoint runs the unary RPCs at every commitment and measures
+// Subscribe latency against one endpoint
+func checkEndpoint(report *doctorReport, config *connect.Config, timeout time.Duration) {
+	prefix := config.Target + " "
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	conn, err := config.Dial(ctx)
+	cancel()
+	if report.run(prefix+"dial", func() (string, error) { return "", err }) != nil {
+		return
+	}
+	defer conn.Close()
+	client := pb.NewGeyserClient(conn)
+
+	call := func(name string, rpc func(ctx context.Context) (string, error)) error {
+		return report.run(prefix+name, func() (string, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return rpc(ctx)
+		})
+	}
+
+	call("ping", func(ctx context.Context) (string, error) {
+		pong, err := client.Ping(ctx, &pb.PingRequest{Count: 1})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("count %d", pong.Count), nil
+	})
+	call("get_version", func(ctx context.Context) (string, error) {
+		version, err := client.GetVersion(ctx, &pb.GetVersionRequest{})
+		if err != nil {
+			return "", err
+		}
+		return version.Version, nil
+	})
+
+	for _, level := range doctorCommitments {
+		commitment := level
+		suffix := " " + strings.ToLower(commitment.String())
+
+		call("get_slot"+suffix, func(ctx context.Context) (string, error) {
+			slot, err := client.GetSlot(ctx, &pb.GetSlotRequest{Commitment: &commitment})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("slot %d", slot.Slot), nil
+		})
+		call("get_block_height"+suffix, func(ctx context.Context) (string, error) {
+			height, err := client.GetBlockHeight(ctx, &pb.GetBlockHeightRequest{Commitment: &commitment})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("height %d", height.BlockHeight), nil
+		})
+
+		var blockhash string
+		err := call("get_latest_blockhash"+suffix, func(ctx context.Context) (string, error) {
+			latest, err := client.GetLatestBlockhash(ctx, &pb.GetLatestBlockhashRequest{Commitment: &commitment})
+			if err != nil {
+				return "", err
+			}
+			blockhash = latest.Blockhash
+			return fmt.Sprintf("%s at slot %d, valid until height %d", latest.Blockhash, latest.Slot, latest.LastValidBlockHeight), nil
+		})
+		if err != nil {
+			continue
+		}
+		call("is_blockhash_valid"+suffix, func(ctx context.Context) (string, error) {
+			valid, err := client.IsBlockhashValid(ctx, &pb.IsBlockhashValidRequest{Blockhash: blockhash, Commitment: &commitment})
+			if err != nil {
+				return "", err
+			}
+			if !valid.Valid {
+				return "", fmt.Errorf("latest blockhash %s reported invalid at slot %d", blockhash, valid.Slot)
+			}
+			return fmt.Sprintf("valid at slot %d", valid.Slot), nil
+		})
+	}
+
+	call("subscribe", func(ctx context.Context) (string, error) {
+		return measureSubscribe(ctx, client)
+	})
+}
+
+// measureSubscribe opens a short slot subscription and reports how long the
+// first message took and how far behind the server's timestamps updates arrive
+func measureSubscribe(ctx context.Context, client pb.GeyserClient) (string, error) {
+	start := time.Now()
+	stream, err := client.Subscribe(ctx)
+	if err != nil {
+		return "", err
+	}
+	commitment := pb.CommitmentLevel_PROCESSED
+	err = stream.Send(&pb.SubscribeRequest{
+		Commitment: &commitment,
+		Slots:      map[string]*pb.SubscribeRequestFilterSlots{"slots": {}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var first, totalLag time.Duration
+	received := 0
+	for received < doctorUpdates {
+		update, err := stream.Recv()
+		if err != nil {
+			if received > 0 {
+				break
+			}
+			return "", err
+		}
+		if update.GetSlot() == nil {
+			continue
+		}
+		now := time.Now()
+		if received == 0 {
+			first = now.Sub(start)
+		}
+		if created := update.GetCreatedAt(); created != nil {
+			totalLag += now.Sub(created.AsTime())
+		}
+		received++
+	}
+
+	return fmt.Sprintf("first message after %v, average lag %v over %d updates",
+		first.Round(time.Millisecond), (totalLag / time.Duration(received)).Round(time.Millisecond), received), nil
+}
+
+// checkDatabase verifies SingleStore connectivity and the schema without
+// creating anything
+func checkDatabase(report *doctorReport) {
+	var dbConn *sql.DB
+	err := report.run("db connect", func() (string, error) {
+		var err error
+		dbConn, err = db.Open()
+		return "", err
+	})
+	if err != nil {
+		return
+	}
+	defer dbConn.Close()
+
+	report.run("db schema", func() (string, error) {
+		missing, err := db.CheckSchema(dbConn)
+		if err != nil {
+			return "", err
+		}
+		if len(missing) > 0 {
+			return "", fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		}
+		return "all tables and columns present", nil
+	})
+}
+
+// printReport writes the report as aligned text
+func printReport(report *doctorReport) {
+	width := 0
+	for _, check := range report.Checks {
+		width = max(width, len(check.Name))
+	}
+	for _, check := range report.Checks {
+		status, message := "PASS", check.Detail
+		if !check.OK {
+			status, message = "FAIL", check.Error
+		}
+		fmt.Printf("%s  %-*s  %8.1fms  %s\n", status, width, check.Name, check.DurationMs, message)
+	}
+	if report.OK {
+		fmt.Println("All checks passed")
+	} else {
+		fmt.Println("Some checks failed")
+	}
+}
@@ -0,0 +1,192 @@
+package fork
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+
+	"goblockstore/parser"
+	pb "goblockstore/proto"
+)
+
+// deadRetention is how many slots a dead or confirmed slot is remembered
+// for, so that a block for it arriving after the slot update is still handled
+const deadRetention = 1000
+
+// Tracker rolls back blocks that were ingested below finalized commitment
+// but did not end up on the canonical fork. Blocks from competing forks are
+// kept side by side at processed; only slots reported dead, or skipped by the
+// parent of a confirmed slot, are rolled back.
+type Tracker struct {
+	DB       *sql.DB
+	Pipeline string
+
+	store     blockStore
+	mu        sync.Mutex
+	dead      map[uint64]struct{}
+	confirmed map[uint64]struct{}
+}
+
+// blockStore is the block storage the tracker inspects and rolls back
+type blockStore interface {
+	// liveSlots returns the slots of live blocks strictly between from and to
+	liveSlots(from, to uint64) ([]uint64, error)
+	// parentOf returns the parent slot of the live block stored at slot
+	parentOf(slot uint64) (uint64, bool, error)
+	rollback(slots []uint64) error
+}
+
+// NewTracker creates a fork tracker that re-points the checkpoint of pipeline
+func NewTracker(db *sql.DB, pipeline string) *Tracker {
+	t := newTracker(&dbStore{db: db, pipeline: pipeline})
+	t.DB = db
+	t.Pipeline = pipeline
+	return t
+}
+
+// newTracker creates a fork tracker on store
+func newTracker(store blockStore) *Tracker {
+	return &Tracker{
+		store:     store,
+		dead:      make(map[uint64]struct{}),
+		confirmed: make(map[uint64]struct{}),
+	}
+}
+
+// OnSlot handles a slot status update. A dead slot is rolled back, and a
+// confirmed or finalized slot rolls back the stored slots its parent skipped.
+func (t *Tracker) OnSlot(update *pb.SubscribeUpdateSlot) error {
+	switch {
+	case update.Status == pb.CommitmentLevel_DEAD || update.DeadError != nil:
+		t.mu.Lock()
+		t.dead[update.Slot] = struct{}{}
+		prune(t.dead, update.Slot)
+		t.mu.Unlock()
+
+		log.Printf("Slot %d is dead (%s), rolling back", update.Slot, update.GetDeadError())
+		if err := t.store.rollback([]uint64{update.Slot}); err != nil {
+			return fmt.Errorf("error rolling back dead slot %d: %v", update.Slot, err)
+		}
+		return nil
+
+	case update.Status == pb.CommitmentLevel_CONFIRMED || update.Status == pb.CommitmentLevel_FINALIZED:
+		t.mu.Lock()
+		t.confirmed[update.Slot] = struct{}{}
+		prune(t.confirmed, update.Slot)
+		t.mu.Unlock()
+
+		if update.Parent != nil {
+			return t.settle(update.Slot, *update.Parent)
+		}
+		// Without a parent in the update, settle once the block is known
+		parent, ok, err := t.store.parentOf(update.Slot)
+		if err != nil || !ok {
+			return err
+		}
+		return t.settle(update.Slot, parent)
+	}
+	return nil
+}
+
+// IsDead reports whether slot has been reported dead or was skipped by the
+// canonical chain
+func (t *Tracker) IsDead(slot uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.dead[slot]
+	return ok
+}
+
+// OnBlock must be called before a block is saved. A block for a slot that was
+// already confirmed settles its ancestry; other blocks roll nothing back, as
+// a competing fork may still win.
+func (t *Tracker) OnBlock(block *parser.Block) error {
+	t.mu.Lock()
+	_, confirmed := t.confirmed[block.Slot]
+	t.mu.Unlock()
+	if !confirmed {
+		return nil
+	}
+	return t.settle(block.Slot, block.ParentSlot)
+}
+
+// settle marks the slots between a confirmed slot and its parent as skipped
+// and rolls back the blocks stored for them
+func (t *Tracker) settle(slot, parent uint64) error {
+	if parent >= slot {
+		return nil
+	}
+
+	t.mu.Lock()
+	for skipped := parent + 1; skipped < slot; skipped++ {
+		t.dead[skipped] = struct{}{}
+	}
+	prune(t.dead, slot)
+	t.mu.Unlock()
+
+	orphaned, err := t.store.liveSlots(parent, slot)
+	if err != nil || len(orphaned) == 0 {
+		return err
+	}
+
+	log.Printf("Confirmed slot %d builds on %d, rolling back orphaned slots %v", slot, parent, orphaned)
+	if err := t.store.rollback(orphaned); err != nil {
+		return fmt.Errorf("error rolling back orphaned slots: %v", err)
+	}
+	return nil
+}
+
+// prune forgets slots more than deadRetention behind current
+func prune(slots map[uint64]struct{}, current uint64) {
+	for slot := range slots {
+		if slot+deadRetention < current {
+			delete(slots, slot)
+		}
+	}
+}
+
+// dbStore is the blockStore backed by the blocks table
+type dbStore struct {
+	db       *sql.DB
+	pipeline string
+}
+
+func (s *dbStore) liveSlots(from, to uint64) ([]uint64, error) {
+	rows, err := s.db.Query(`
+		SELECT slot FROM blocks
+		WHERE deleted_at IS NULL AND slot > ? AND slot < ?`,
+		from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error querying blocks above parent %d: %v", from, err)
+	}
+	defer rows.Close()
+
+	var slots []uint64
+	for rows.Next() {
+		var slot uint64
+		if err := rows.Scan(&slot); err != nil {
+			return nil, fmt.Errorf("error scanning block: %v", err)
+		}
+		slots = append(slots, slot)
+	}
+	return slots, rows.Err()
+}
+
+func (s *dbStore) parentOf(slot uint64) (uint64, bool, error) {
+	var parent uint64
+	err := s.db.QueryRow(`
+		SELECT parent_slot FROM blocks
+		WHERE deleted_at IS NULL AND slot = ?`, slot).Scan(&parent)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error querying parent of block %d: %v", slot, err)
+	}
+	return parent, true, nil
+}
+
+func (s *dbStore) rollback(slots []uint64) error {
+	return parser.RollbackSlots(s.db, slots, s.pipeline)
+}
@@ -0,0 +1,111 @@
+package fork
+
+import (
+	"sort"
+	"testing"
+
+	"goblockstore/parser"
+	pb "goblockstore/proto"
+)
+
+// memStore is an in-memory blockStore mapping live slots to their parents
+type memStore map[uint64]uint64
+
+func (m memStore) liveSlots(from, to uint64) ([]uint64, error) {
+	var slots []uint64
+	for slot := range m {
+		if slot > from && slot < to {
+			slots = append(slots, slot)
+		}
+	}
+	sort.Slice(slots, func(a, b int) bool { return slots[a] < slots[b] })
+	return slots, nil
+}
+
+func (m memStore) parentOf(slot uint64) (uint64, bool, error) {
+	parent, ok := m[slot]
+	return parent, ok, nil
+}
+
+func (m memStore) rollback(slots []uint64) error {
+	for _, slot := range slots {
+		delete(m, slot)
+	}
+	return nil
+}
+
+// save runs a block through the tracker and stores it like the indexer does
+func save(t *testing.T, tracker *Tracker, store memStore, slot, parent uint64) {
+	t.Helper()
+	if tracker.IsDead(slot) {
+		return
+	}
+	if err := tracker.OnBlock(&parser.Block{Slot: slot, ParentSlot: parent}); err != nil {
+		t.Fatalf("OnBlock(%d): %v", slot, err)
+	}
+	store[slot] = parent
+}
+
+func TestTrackerForkThenContinuation(t *testing.T) {
+	store := memStore{10: 9}
+	tracker := newTracker(store)
+
+	// Fork A builds 11 on 10, a minority fork B builds 12 on 10 and arrives late
+	save(t, tracker, store, 11, 10)
+	save(t, tracker, store, 12, 10)
+	if _, ok := store[11]; !ok {
+		t.Fatal("block 11 of fork A rolled back by a block of fork B at processed")
+	}
+
+	// A continues with 13 on 11 and is confirmed
+	save(t, tracker, store, 13, 11)
+	parent := uint64(11)
+	if err := tracker.OnSlot(&pb.SubscribeUpdateSlot{Slot: 13, Parent: &parent, Status: pb.CommitmentLevel_CONFIRMED}); err != nil {
+		t.Fatalf("OnSlot: %v", err)
+	}
+
+	for _, slot := range []uint64{10, 11, 13} {
+		if _, ok := store[slot]; !ok {
+			t.Errorf("canonical block %d rolled back", slot)
+		}
+	}
+	if _, ok := store[12]; ok {
+		t.Error("block 12 of fork B not rolled back after 13 was confirmed")
+	}
+
+	// A replay of fork B is rejected
+	save(t, tracker, store, 12, 10)
+	if _, ok := store[12]; ok {
+		t.Error("block 12 of fork B stored again after being skipped")
+	}
+}
+
+func TestTrackerConfirmedBeforeBlock(t *testing.T) {
+	store := memStore{10: 9, 12: 10}
+	tracker := newTracker(store)
+
+	// The slot is confirmed before its block arrives and without a parent
+	if err := tracker.OnSlot(&pb.SubscribeUpdateSlot{Slot: 13, Status: pb.CommitmentLevel_CONFIRMED}); err != nil {
+		t.Fatalf("OnSlot: %v", err)
+	}
+	save(t, tracker, store, 13, 11)
+
+	if _, ok := store[12]; ok {
+		t.Error("block 12 not rolled back once the confirmed block 13 arrived")
+	}
+}
+
+func TestTrackerDeadSlot(t *testing.T) {
+	store := memStore{10: 9, 11: 10}
+	tracker := newTracker(store)
+
+	if err := tracker.OnSlot(&pb.SubscribeUpdateSlot{Slot: 11, Status: pb.CommitmentLevel_DEAD}); err != nil {
+		t.Fatalf("OnSlot: %v", err)
+	}
+	if _, ok := store[11]; ok {
+		t.Error("dead slot 11 not rolled back")
+	}
+	if !tracker.IsDead(11) {
+		t.Error("slot 11 not reported dead")
+	}
+}
@@ -6,7 +6,10 @@ toolchain go1.23.3
 
 require google.golang.org/grpc v1.70.0
 
-require github.com/mr-tron/base58 v1.2.0
+require (
+	github.com/mr-tron/base58 v1.2.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
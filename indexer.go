@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"goblockstore/accounts"
+	"goblockstore/fork"
+	"goblockstore/parser"
+	pb "goblockstore/proto"
+	"goblockstore/sigtracker"
+	"goblockstore/txstream"
+)
+
+// indexer turns Geyser updates into database rows
+type indexer struct {
+	db     *sql.DB
+	cursor *parser.Checkpoint
+	// forks is set when ingesting below finalized commitment
+	forks *fork.Tracker
+	// bootstrap is set when a startup account snapshot should be collected
+	bootstrap *accounts.Bootstrapper
+	// txs is set when transactions are streamed ahead of their blocks
+	txs *txstream.Reconciler
+	// signatures is set when the signature confirmation tracker is enabled
+	signatures *sigtracker.Tracker
+}
+
+// prepareUpdate parses an update and returns the write that completes it.
+// It is safe to call concurrently, but the returned writes must be applied
+// in stream order. Save failures are returned by the write so it is retried
+// instead of dropping data.
+func (idx *indexer) prepareUpdate(m *pb.SubscribeUpdate) func() error {
+	switch {
+	case m.GetSlot() != nil:
+		return idx.prepareSlot(m.GetSlot(), m.GetCreatedAt().AsTime())
+	case m.GetAccount() != nil:
+		return idx.prepareAccount(m.GetAccount())
+	case m.GetBlock() != nil:
+		return idx.prepareBlock(m.GetBlock())
+	case m.GetBlockMeta() != nil:
+		return idx.prepareBlockMeta(m.GetBlockMeta())
+	case m.GetTransaction() != nil && idx.txs != nil:
+		return idx.prepareTransaction(m.GetTransaction())
+	}
+	return noWrite
+}
+
+// trackStatuses answers tracked signatures as soon as their status arrives
+// and passes every other update on to next. Statuses are never stored, so
+// they skip the pipeline and a slow database cannot delay them.
+func (idx *indexer) trackStatuses(next func(update *pb.SubscribeUpdate) error) func(update *pb.SubscribeUpdate) error {
+	return func(update *pb.SubscribeUpdate) error {
+		if status := update.GetTransactionStatus(); status != nil {
+			idx.signatures.OnStatus(status)
+			return nil
+		}
+		return next(update)
+	}
+}
+
+// noWrite completes updates that need nothing written
+func noWrite() error {
+	return nil
+}
+
+// advancesCheckpoint reports whether writing update moves the pipeline
+// checkpoint, so giving up on it would let a later write skip past it
+func advancesCheckpoint(m *pb.SubscribeUpdate) bool {
+	return m.GetBlock() != nil || m.GetBlockMeta() != nil
+}
+
+// prepareSlot returns the write recording the slot timeline and rolling back
+// dead slots. seenAt is the time the Geyser server emitted the update.
+func (idx *indexer) prepareSlot(slot *pb.SubscribeUpdateSlot, seenAt time.Time) func() error {
+	if seenAt.Unix() <= 0 {
+		seenAt = time.Now()
+	}
+	status := parser.ParseSlotStatus(slot, seenAt)
+
+	return func() error {
+		if err := parser.SaveSlotStatus(idx.db, status); err != nil {
+			return err
+		}
+		if idx.forks != nil {
+			return idx.forks.OnSlot(slot)
+		}
+		return nil
+	}
+}
+
+// prepareAccount returns the write storing an account update and advancing
+// the account's latest state
+func (idx *indexer) prepareAccount(update *pb.SubscribeUpdateAccount) func() error {
+	account, err := parser.ParseAccountUpdate(update)
+	if err != nil {
+		log.Printf("Failed to parse account update: %v", err)
+		return noWrite
+	}
+
+	return func() error {
+		if idx.bootstrap != nil {
+			handled, err := idx.bootstrap.Handle(account, update.IsStartup)
+			if err != nil || handled {
+				return err
+			}
+		}
+		if err := parser.SaveAccountUpdate(idx.db, account); err != nil {
+			return fmt.Errorf("failed to save account %s at slot %d: %v", account.Pubkey, account.Slot, err)
+		}
+		return nil
+	}
+}
+
+// prepareBlock parses a block and returns the write saving it together with
+// the pipeline checkpoint
+func (idx *indexer) prepareBlock(block *pb.SubscribeUpdateBlock) func() error {
+	startTime := time.Now()
+	// Parse the block
+	parsedBlock, err := parser.ParseBlock(block)
+	if err != nil {
+		log.Printf("Failed to parse block %d: %v", block.Slot, err)
+		return noWrite
+	}
+	timeTaken := time.Since(startTime)
+	log.Printf("Time taken to parse block: %v, block number: %d, raw tx len: %d, parsed tx len: %d", timeTaken, block.BlockHeight.GetBlockHeight(), len(block.Transactions), len(parsedBlock.Transactions))
+
+	return func() error {
+		// Checked at write time, after the slot updates received before the block
+		if idx.forks != nil && idx.forks.IsDead(block.Slot) {
+			log.Printf("Skipping block %d from dead slot", block.Slot)
+			return nil
+		}
+
+		saved, err := idx.saveBlock(parsedBlock)
+		if err != nil || !saved {
+			return err
+		}
+
+		log.Printf("Successfully processed block %d with %d transactions", block.Slot, len(parsedBlock.Transactions))
+		return nil
+	}
+}
+
+// prepareBlockMeta parses a block header and returns the write saving it
+// and its rewards
+func (idx *indexer) prepareBlockMeta(meta *pb.SubscribeUpdateBlockMeta) func() error {
+	parsedBlock, err := parser.ParseBlockMeta(meta)
+	if err != nil {
+		log.Printf("Failed to parse block header %d: %v", meta.Slot, err)
+		return noWrite
+	}
+
+	return func() error {
+		if idx.forks != nil && idx.forks.IsDead(meta.Slot) {
+			log.Printf("Skipping block header %d from dead slot", meta.Slot)
+			return nil
+		}
+
+		saved, err := idx.saveBlock(parsedBlock)
+		if err != nil {
+			return err
+		}
+		if idx.txs != nil {
+			if err := idx.txs.OnBlock(&parsedBlock.Block); err != nil {
+				return err
+			}
+		}
+		if !saved {
+			return nil
+		}
+
+		log.Printf("Successfully processed block header %d with %d rewards", meta.Slot, len(parsedBlock.BlockRewards))
+		return nil
+	}
+}
+
+// prepareTransaction parses a transaction streamed ahead of its block and
+// returns the write saving it provisionally
+func (idx *indexer) prepareTransaction(update *pb.SubscribeUpdateTransaction) func() error {
+	if update.GetTransaction().GetIsVote() {
+		return noWrite
+	}
+
+	parsed, err := parser.ParseTransaction(update)
+	if err != nil {
+		log.Printf("Failed to parse transaction at slot %d: %v", update.Slot, err)
+		return noWrite
+	}
+
+	return func() error {
+		return idx.txs.OnTransaction(parsed)
+	}
+}
+
+// saveBlock rolls back orphaned forks and saves the block together with the
+// pipeline checkpoint. It reports whether new rows were written.
+func (idx *indexer) saveBlock(parsedBlock *parser.ParsedBlock) (bool, error) {
+	if idx.forks != nil {
+		if err := idx.forks.OnBlock(&parsedBlock.Block); err != nil {
+			return false, err
+		}
+	}
+
+	// Save to database
+	saved, err := parser.SaveBlock(idx.db, parsedBlock, idx.cursor)
+	if err != nil {
+		return false, fmt.Errorf("failed to save block %d to database: %v", parsedBlock.Block.Slot, err)
+	}
+	if !saved {
+		log.Printf("Block %d already stored, checkpoint advanced", parsedBlock.Block.Slot)
+	}
+	return saved, nil
+}
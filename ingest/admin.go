@@ -0,0 +1,88 @@
+package ingest
+
+import (
+	"io"
+	"net/http"
+
+	pb "goblockstore/proto"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Handler serves an admin API for runtime subscription changes:
+//
+//	GET    /subscriptions                current merged request
+//	PUT    /subscriptions/{kind}/{name}  add or replace a filter, body is the filter as protobuf JSON
+//	DELETE /subscriptions/{kind}/{name}  remove a runtime filter
+//
+// kind is one of accounts, transactions, transactions_status or slots.
+func (s *Subscriptions) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /subscriptions", s.handleGet)
+	mux.HandleFunc("PUT /subscriptions/{kind}/{name}", s.handlePut)
+	mux.HandleFunc("DELETE /subscriptions/{kind}/{name}", s.handleDelete)
+	return mux
+}
+
+func (s *Subscriptions) handleGet(w http.ResponseWriter, r *http.Request) {
+	writeProto(w, s.Request())
+}
+
+func (s *Subscriptions) handlePut(w http.ResponseWriter, r *http.Request) {
+	kind, name := r.PathValue("kind"), r.PathValue("name")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var filter proto.Message
+	var apply func() error
+	switch kind {
+	case KindAccounts:
+		f := &pb.SubscribeRequestFilterAccounts{}
+		filter, apply = f, func() error { return s.SetAccounts(name, f) }
+	case KindTransactions:
+		f := &pb.SubscribeRequestFilterTransactions{}
+		filter, apply = f, func() error { return s.SetTransactions(name, f) }
+	case KindTransactionsStatus:
+		f := &pb.SubscribeRequestFilterTransactions{}
+		filter, apply = f, func() error { return s.SetTransactionsStatus(name, f) }
+	case KindSlots:
+		f := &pb.SubscribeRequestFilterSlots{}
+		filter, apply = f, func() error { return s.SetSlots(name, f) }
+	default:
+		http.Error(w, "unknown filter kind "+kind, http.StatusNotFound)
+		return
+	}
+
+	if err := protojson.Unmarshal(body, filter); err != nil {
+		http.Error(w, "invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := apply(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeProto(w, s.Request())
+}
+
+func (s *Subscriptions) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.Remove(r.PathValue("kind"), r.PathValue("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeProto(w, s.Request())
+}
+
+// writeProto writes m as a protobuf JSON response
+func writeProto(w http.ResponseWriter, m proto.Message) {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,265 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+// Retention of update keys, in slots behind the newest one
+const (
+	dedupRetention = 1000
+	// dedupShortRetention applies to transactions, transaction statuses and
+	// account writes, which arrive by the thousand per slot
+	dedupShortRetention = 64
+)
+
+// EndpointStats summarizes how an endpoint compares to the others
+type EndpointStats struct {
+	Endpoint string `json:"endpoint"`
+	// Received counts all deduplicated updates delivered by the endpoint
+	Received uint64 `json:"received"`
+	// First counts updates the endpoint delivered before any other
+	First uint64 `json:"first"`
+	// Duplicates counts updates another endpoint already delivered
+	Duplicates uint64 `json:"duplicates"`
+	// TotalLag sums how far behind the first copy each duplicate arrived
+	TotalLag time.Duration `json:"total_lag"`
+}
+
+// AverageLag returns the mean delay of the endpoint's duplicate updates
+func (s EndpointStats) AverageLag() time.Duration {
+	if s.Duplicates == 0 {
+		return 0
+	}
+	return s.TotalLag / time.Duration(s.Duplicates)
+}
+
+// slotKeys holds the keys of handled updates and when they arrived, per
+// slot, so whole slots can be forgotten at once
+type slotKeys map[uint64]map[string]time.Time
+
+// get returns when the update with key at slot was handled
+func (s slotKeys) get(slot uint64, key string) (time.Time, bool) {
+	at, ok := s[slot][key]
+	return at, ok
+}
+
+// add records the update with key at slot as handled at at
+func (s slotKeys) add(slot uint64, key string, at time.Time) {
+	keys, ok := s[slot]
+	if !ok {
+		keys = make(map[string]time.Time)
+		s[slot] = keys
+	}
+	keys[key] = at
+}
+
+// prune forgets the slots before cutoff
+func (s slotKeys) prune(cutoff uint64) {
+	for slot := range s {
+		if slot < cutoff {
+			delete(s, slot)
+		}
+	}
+}
+
+// Deduper merges the streams of several endpoints, passing on only the first
+// copy of each block, transaction, account write or slot status. Updates are
+// handed to the downstream handler one at a time, and a copy is only marked
+// as seen once the handler accepted it, so a copy it rejects, e.g. while the
+// pipeline is stopping, can still be taken from another endpoint.
+type Deduper struct {
+	// AccountsFrom, when set, names the only endpoint account updates are
+	// taken from. Write versions are counted by each validator on its own,
+	// so ordering writes from several endpoints by them could let a stale
+	// write win within a slot.
+	AccountsFrom string
+
+	handle func(update *pb.SubscribeUpdate) error
+
+	mu sync.Mutex
+	// seen and seenShort hold the keys kept for dedupRetention and
+	// dedupShortRetention slots
+	seen      slotKeys
+	seenShort slotKeys
+	maxSlot   uint64
+	stats     map[string]*EndpointStats
+	handling  sync.Mutex
+}
+
+// NewDeduper creates a deduper in front of handle
+func NewDeduper(handle func(update *pb.SubscribeUpdate) error) *Deduper {
+	return &Deduper{
+		handle:    handle,
+		seen:      make(slotKeys),
+		seenShort: make(slotKeys),
+		stats:     make(map[string]*EndpointStats),
+	}
+}
+
+// Handler returns the update handler for the supervisor of one endpoint
+func (d *Deduper) Handler(endpoint string) func(update *pb.SubscribeUpdate) error {
+	d.mu.Lock()
+	if _, ok := d.stats[endpoint]; !ok {
+		d.stats[endpoint] = &EndpointStats{Endpoint: endpoint}
+	}
+	d.mu.Unlock()
+
+	return func(update *pb.SubscribeUpdate) error {
+		return d.Handle(endpoint, update)
+	}
+}
+
+// Handle passes update on unless another endpoint already delivered it
+func (d *Deduper) Handle(endpoint string, update *pb.SubscribeUpdate) error {
+	if update.GetAccount() != nil && d.AccountsFrom != "" && endpoint != d.AccountsFrom {
+		return nil
+	}
+
+	key, slot, short, ok := updateKey(update)
+	if !ok {
+		d.handling.Lock()
+		defer d.handling.Unlock()
+		return d.handle(update)
+	}
+
+	// Serialize downstream handling so the first copy wins deterministically
+	d.handling.Lock()
+	defer d.handling.Unlock()
+
+	seen := d.seen
+	if short {
+		seen = d.seenShort
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	stats := d.stats[endpoint]
+	stats.Received++
+	if first, dup := seen.get(slot, key); dup {
+		stats.Duplicates++
+		stats.TotalLag += now.Sub(first)
+		d.mu.Unlock()
+		return nil
+	}
+	d.mu.Unlock()
+
+	if err := d.handle(update); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats.First++
+	seen.add(slot, key, now)
+	if slot > d.maxSlot {
+		d.maxSlot = slot
+		d.prune()
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the per-endpoint statistics
+func (d *Deduper) Stats() []EndpointStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := make([]EndpointStats, 0, len(d.stats))
+	for _, stats := range d.stats {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Endpoint < result[b].Endpoint })
+	return result
+}
+
+// LogStats logs the per-endpoint statistics every interval until ctx is cancelled
+func (d *Deduper) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, stats := range d.Stats() {
+			log.Printf("Endpoint %s: received %d, first %d, duplicates %d, average lag %v",
+				stats.Endpoint, stats.Received, stats.First, stats.Duplicates, stats.AverageLag())
+		}
+	}
+}
+
+// prune forgets keys of slots far behind the newest one. d.mu must be held.
+func (d *Deduper) prune() {
+	if d.maxSlot >= dedupRetention {
+		d.seen.prune(d.maxSlot - dedupRetention)
+	}
+	if d.maxSlot >= dedupShortRetention {
+		d.seenShort.prune(d.maxSlot - dedupShortRetention)
+	}
+}
+
+// updateKey identifies an update independently of the endpoint it came from
+// and reports whether its kind is kept for dedupShortRetention slots only.
+// Keepalive and unknown updates are not deduplicated.
+func updateKey(update *pb.SubscribeUpdate) (key string, slot uint64, short, ok bool) {
+	switch {
+	case update.GetBlock() != nil:
+		block := update.GetBlock()
+		return fmt.Sprintf("block:%s", block.Blockhash), block.Slot, false, true
+	case update.GetBlockMeta() != nil:
+		meta := update.GetBlockMeta()
+		return fmt.Sprintf("block_meta:%s", meta.Blockhash), meta.Slot, false, true
+	case update.GetTransaction() != nil:
+		tx := update.GetTransaction()
+		return "transaction:" + base58.Encode(tx.GetTransaction().GetSignature()), tx.Slot, true, true
+	case update.GetTransactionStatus() != nil:
+		status := update.GetTransactionStatus()
+		return "transaction_status:" + base58.Encode(status.Signature), status.Slot, true, true
+	case update.GetAccount() != nil:
+		account := update.GetAccount()
+		return "account:" + base58.Encode(account.GetAccount().GetPubkey()) + ":" + accountWriteKey(account.GetAccount()),
+			account.Slot, true, true
+	case update.GetSlot() != nil:
+		slot := update.GetSlot()
+		return fmt.Sprintf("slot:%d", slot.Status), slot.Slot, false, true
+	case update.GetEntry() != nil:
+		entry := update.GetEntry()
+		return fmt.Sprintf("entry:%d", entry.Index), entry.Slot, false, true
+	}
+	return "", 0, false, false
+}
+
+// accountWriteKey identifies an account write within its slot by the
+// transaction that made it, or by the resulting state for writes outside
+// transactions. The write version is local to a validator and differs
+// between endpoints.
+func accountWriteKey(info *pb.SubscribeUpdateAccountInfo) string {
+	if len(info.GetTxnSignature()) > 0 {
+		return base58.Encode(info.GetTxnSignature())
+	}
+
+	hash := sha256.New()
+	var fields [17]byte
+	binary.BigEndian.PutUint64(fields[0:8], info.GetLamports())
+	binary.BigEndian.PutUint64(fields[8:16], info.GetRentEpoch())
+	if info.GetExecutable() {
+		fields[16] = 1
+	}
+	hash.Write(fields[:])
+	hash.Write(info.GetOwner())
+	hash.Write(info.GetData())
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,155 @@
+package ingest
+
+import (
+	"errors"
+	"testing"
+
+	pb "goblockstore/proto"
+)
+
+func slotUpdate(slot uint64) *pb.SubscribeUpdate {
+	return &pb.SubscribeUpdate{UpdateOneof: &pb.SubscribeUpdate_Slot{Slot: &pb.SubscribeUpdateSlot{Slot: slot}}}
+}
+
+func statusUpdate(slot uint64, signature byte) *pb.SubscribeUpdate {
+	return &pb.SubscribeUpdate{UpdateOneof: &pb.SubscribeUpdate_TransactionStatus{
+		TransactionStatus: &pb.SubscribeUpdateTransactionStatus{Slot: slot, Signature: []byte{signature}},
+	}}
+}
+
+func TestDeduperWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   *pb.SubscribeUpdate
+		advance uint64
+		handled int
+	}{
+		{"slot duplicate", slotUpdate(100), 0, 1},
+		{"slot within retention", slotUpdate(100), dedupRetention, 1},
+		{"slot beyond retention", slotUpdate(100), dedupRetention + 1, 2},
+		{"status duplicate", statusUpdate(100, 1), 0, 1},
+		{"status within short retention", statusUpdate(100, 1), dedupShortRetention, 1},
+		{"status beyond short retention", statusUpdate(100, 1), dedupShortRetention + 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := 0
+			d := NewDeduper(func(update *pb.SubscribeUpdate) error {
+				if update.GetSlot() == nil || update.GetSlot().Slot == 100 || update.GetTransactionStatus() != nil {
+					handled++
+				}
+				return nil
+			})
+			a, b := d.Handler("a"), d.Handler("b")
+
+			a(tt.first)
+			if tt.advance > 0 {
+				a(slotUpdate(100 + tt.advance))
+			}
+			b(tt.first)
+
+			if handled != tt.handled {
+				t.Errorf("handled %d copies, want %d", handled, tt.handled)
+			}
+		})
+	}
+}
+
+func TestDeduperRetriesRejectedCopy(t *testing.T) {
+	fail := true
+	handled := 0
+	d := NewDeduper(func(*pb.SubscribeUpdate) error {
+		if fail {
+			return errors.New("stopped")
+		}
+		handled++
+		return nil
+	})
+
+	a, b := d.Handler("a"), d.Handler("b")
+	if err := a(slotUpdate(7)); err == nil {
+		t.Fatal("rejected copy reported as handled")
+	}
+	fail = false
+	if err := b(slotUpdate(7)); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if handled != 1 {
+		t.Errorf("handled %d copies, want the second endpoint's copy", handled)
+	}
+
+	stats := d.Stats()
+	if stats[1].Endpoint != "b" || stats[1].First != 1 {
+		t.Errorf("stats %+v, want b first", stats)
+	}
+}
+
+func TestDeduperAccountWrites(t *testing.T) {
+	account := func(writeVersion uint64, signature, data byte) *pb.SubscribeUpdate {
+		info := &pb.SubscribeUpdateAccountInfo{Pubkey: []byte{1}, Lamports: 10, WriteVersion: writeVersion, Data: []byte{data}}
+		if signature != 0 {
+			info.TxnSignature = []byte{signature}
+		}
+		return &pb.SubscribeUpdate{UpdateOneof: &pb.SubscribeUpdate_Account{
+			Account: &pb.SubscribeUpdateAccount{Slot: 100, Account: info},
+		}}
+	}
+	tests := []struct {
+		name    string
+		first   *pb.SubscribeUpdate
+		second  *pb.SubscribeUpdate
+		handled int
+	}{
+		{"same transaction, other write version", account(1, 7, 0), account(900, 7, 0), 1},
+		{"other transaction", account(1, 7, 0), account(1, 8, 0), 2},
+		{"same state outside transactions", account(1, 0, 3), account(900, 0, 3), 1},
+		{"other state outside transactions", account(1, 0, 3), account(1, 0, 4), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := 0
+			d := NewDeduper(func(*pb.SubscribeUpdate) error {
+				handled++
+				return nil
+			})
+			a, b := d.Handler("a"), d.Handler("b")
+			if err := a(tt.first); err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			if err := b(tt.second); err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			if handled != tt.handled {
+				t.Errorf("handled %d, want %d", handled, tt.handled)
+			}
+		})
+	}
+}
+
+func TestDeduperAccountsFrom(t *testing.T) {
+	var handled []uint64
+	d := NewDeduper(func(update *pb.SubscribeUpdate) error {
+		handled = append(handled, update.GetAccount().GetSlot()+update.GetSlot().GetSlot())
+		return nil
+	})
+	d.AccountsFrom = "a"
+	a, b := d.Handler("a"), d.Handler("b")
+
+	account := func(slot uint64) *pb.SubscribeUpdate {
+		return &pb.SubscribeUpdate{UpdateOneof: &pb.SubscribeUpdate_Account{
+			Account: &pb.SubscribeUpdateAccount{Slot: slot, Account: &pb.SubscribeUpdateAccountInfo{Pubkey: []byte{1}, TxnSignature: []byte{byte(slot)}}},
+		}}
+	}
+	for _, handle := range []func(*pb.SubscribeUpdate) error{b, a} {
+		if err := handle(account(5)); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+	// Other updates are still taken from whichever endpoint is first
+	if err := b(slotUpdate(6)); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if len(handled) != 2 || handled[0] != 5 || handled[1] != 6 {
+		t.Errorf("handled %v, want [5 6]", handled)
+	}
+}
@@ -0,0 +1,156 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	pb "goblockstore/proto"
+)
+
+// Keepalive defaults
+const (
+	DefaultPingInterval   = 10 * time.Second
+	DefaultMaxMissedPongs = 3
+)
+
+// ErrPongTimeout ends a session whose stream stopped delivering anything,
+// pongs included
+var ErrPongTimeout = errors.New("geyser stream missed too many pongs")
+
+// serverPingReplyID is the ping id used when answering a server ping. Its pong
+// is not one of ours and is ignored.
+const serverPingReplyID = 0
+
+// keepalive sends application-level pings on a session, measures the round
+// trip from the matching pongs and ends sessions whose stream went silent.
+// Silence is only counted while the receive loop waits on the stream, so a
+// handler blocked on backpressure never tears down a healthy stream.
+type keepalive struct {
+	session   *Session
+	interval  time.Duration
+	maxMissed int
+	onRTT     func(time.Duration)
+
+	mu     sync.Mutex
+	nextID int32
+	sent   map[int32]time.Time
+	// lastSeen is when the stream last delivered a message or the receive
+	// loop last went back to reading it, and busy is set in between
+	lastSeen time.Time
+	busy     bool
+}
+
+func newKeepalive(session *Session, interval time.Duration, maxMissed int, onRTT func(time.Duration)) *keepalive {
+	if interval <= 0 {
+		interval = DefaultPingInterval
+	}
+	if maxMissed <= 0 {
+		maxMissed = DefaultMaxMissedPongs
+	}
+	return &keepalive{
+		session:   session,
+		interval:  interval,
+		maxMissed: maxMissed,
+		onRTT:     onRTT,
+		sent:      make(map[int32]time.Time),
+		lastSeen:  time.Now(),
+	}
+}
+
+// run pings every interval until ctx is done. A stream that delivers nothing,
+// pongs included, for maxMissed intervals while it is being read cancels the
+// session with ErrPongTimeout.
+func (k *keepalive) run(ctx context.Context, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if k.silence() >= k.timeout() {
+			cancel(ErrPongTimeout)
+			return
+		}
+		if err := k.ping(); err != nil {
+			cancel(err)
+			return
+		}
+	}
+}
+
+// timeout is how long a stream may stay silent
+func (k *keepalive) timeout() time.Duration {
+	return time.Duration(k.maxMissed) * k.interval
+}
+
+// ping sends the next numbered ping
+func (k *keepalive) ping() error {
+	k.mu.Lock()
+	k.nextID++
+	if k.nextID <= serverPingReplyID {
+		k.nextID = serverPingReplyID + 1
+	}
+	id := k.nextID
+	k.sent[id] = time.Now()
+	k.mu.Unlock()
+
+	return k.session.Send(&pb.SubscribeRequest{Ping: &pb.SubscribeRequestPing{Id: id}})
+}
+
+// received records that the stream delivered a message
+func (k *keepalive) received() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastSeen = time.Now()
+}
+
+// handling pauses the silence clock while the receive loop hands an update
+// downstream, and restarts it once the loop reads the stream again
+func (k *keepalive) handling(busy bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.busy = busy
+	k.lastSeen = time.Now()
+}
+
+// silence returns how long the stream has been quiet while being read, zero
+// while an update is handled. Pings too old to still be answered are dropped.
+func (k *keepalive) silence() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-k.timeout())
+	for id, sentAt := range k.sent {
+		if sentAt.Before(cutoff) {
+			delete(k.sent, id)
+		}
+	}
+	if k.busy {
+		return 0
+	}
+	return now.Sub(k.lastSeen)
+}
+
+// pong records the answer to one of our pings
+func (k *keepalive) pong(id int32) {
+	k.mu.Lock()
+	sentAt, ok := k.sent[id]
+	delete(k.sent, id)
+	k.mu.Unlock()
+
+	if ok && k.onRTT != nil {
+		k.onRTT(time.Since(sentAt))
+	}
+}
+
+// replyToServer answers a ping initiated by the server
+func (k *keepalive) replyToServer() error {
+	return k.session.Send(&pb.SubscribeRequest{Ping: &pb.SubscribeRequestPing{Id: serverPingReplyID}})
+}
@@ -0,0 +1,70 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepaliveSilence(t *testing.T) {
+	interval := time.Minute
+	tests := []struct {
+		name     string
+		lastSeen time.Duration // age of the last message
+		busy     bool
+		timeout  bool
+	}{
+		{"recent message", time.Second, false, false},
+		{"quiet for a while", 2 * interval, false, false},
+		{"silent too long", 3 * interval, false, true},
+		{"handler blocked", 10 * interval, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKeepalive(nil, interval, 0, nil)
+			k.lastSeen = time.Now().Add(-tt.lastSeen)
+			k.busy = tt.busy
+			if got := k.silence() >= k.timeout(); got != tt.timeout {
+				t.Errorf("timed out %v, want %v", got, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestKeepaliveHandlingRestartsClock(t *testing.T) {
+	k := newKeepalive(nil, time.Minute, 0, nil)
+	k.lastSeen = time.Now().Add(-time.Hour)
+	k.handling(true)
+	if got := k.silence(); got != 0 {
+		t.Errorf("silence %v while handling, want 0", got)
+	}
+	k.handling(false)
+	if got := k.silence(); got >= time.Minute {
+		t.Errorf("silence %v after handling, want the clock restarted", got)
+	}
+	if k.maxMissed != DefaultMaxMissedPongs {
+		t.Errorf("max missed %d, want default %d", k.maxMissed, DefaultMaxMissedPongs)
+	}
+}
+
+func TestKeepalivePongs(t *testing.T) {
+	var rtts int
+	k := newKeepalive(nil, time.Minute, 0, func(time.Duration) { rtts++ })
+	k.sent[1] = time.Now().Add(-time.Hour)
+	k.sent[2] = time.Now().Add(-time.Second)
+	k.sent[3] = time.Now()
+
+	// Pings too old to be answered are dropped
+	k.silence()
+	if _, ok := k.sent[1]; ok {
+		t.Error("stale ping kept")
+	}
+	k.pong(1)
+	k.pong(serverPingReplyID)
+	k.pong(2)
+	if rtts != 1 {
+		t.Errorf("%d round trips reported, want 1", rtts)
+	}
+	if len(k.sent) != 1 {
+		t.Errorf("%d pings outstanding, want 1", len(k.sent))
+	}
+}
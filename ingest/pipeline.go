@@ -0,0 +1,336 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	pb "goblockstore/proto"
+)
+
+// Pipeline defaults
+const (
+	DefaultParseQueue    = 64
+	DefaultWriteQueue    = 256
+	DefaultWriteAttempts = 5
+)
+
+// ErrPipelineStopped is returned for updates submitted after the pipeline stopped
+var ErrPipelineStopped = errors.New("ingest pipeline stopped")
+
+// metrics exposes the pipeline counters and queue depths under /debug/vars
+var metrics = expvar.NewMap("ingest")
+
+// Pipeline decouples receiving from parsing and writing. Submitted updates are
+// parsed by a pool of workers and their writes applied one at a time in
+// submission order, so a slow database no longer stalls the gRPC stream until
+// the bounded queues fill up.
+type Pipeline struct {
+	// Prepare parses an update and returns the write completing it. It is
+	// called concurrently from the parse workers.
+	Prepare func(update *pb.SubscribeUpdate) func() error
+
+	// ParseWorkers defaults to the number of CPUs
+	ParseWorkers int
+	// ParseQueue and WriteQueue bound the updates waiting for each stage
+	ParseQueue int
+	WriteQueue int
+
+	// MinBackoff and MaxBackoff bound the delay between retries of a failed write
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// WriteAttempts bounds how often a write is tried while the database is
+	// healthy. A write failing that often is given up on, so one bad row
+	// cannot wedge the queues. Defaults to DefaultWriteAttempts.
+	WriteAttempts int
+	// MustWrite, when set, reports whether an update may not be given up on,
+	// e.g. because later writes would advance a checkpoint past it. Giving
+	// up on such an update stops the pipeline with an error instead; Done
+	// and Err report it.
+	MustWrite func(update *pb.SubscribeUpdate) bool
+	// Healthy, when set, reports whether the database is reachable. Failures
+	// while it returns an error do not count against WriteAttempts, so an
+	// outage is waited out rather than dropping updates.
+	Healthy func() error
+
+	once      sync.Once
+	mu        sync.Mutex
+	parse     chan *pipelineJob
+	write     chan *pipelineJob
+	done      chan struct{}
+	stopping  chan struct{}
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	drained   chan struct{}
+	errMu     sync.Mutex
+	err       error
+	parsed    expvar.Int
+	wrote     expvar.Int
+	retry     expvar.Int
+	failed    expvar.Int
+}
+
+// pipelineJob carries an update through the stages. done is closed once the
+// write has been prepared.
+type pipelineJob struct {
+	update *pb.SubscribeUpdate
+	write  func() error
+	done   chan struct{}
+	// written, when set, is called once the write succeeded or was given up on
+	written func()
+}
+
+// Run starts the parse workers and the writer and blocks until ctx is cancelled
+func (p *Pipeline) Run(ctx context.Context) {
+	p.init()
+	defer close(p.done)
+
+	workers := p.ParseWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	for i := 0; i < workers; i++ {
+		go p.parseWorker(ctx)
+	}
+	p.writer(ctx)
+}
+
+// init creates the queues and publishes the metrics
+func (p *Pipeline) init() {
+	p.once.Do(func() {
+		parseQueue, writeQueue := p.ParseQueue, p.WriteQueue
+		if parseQueue <= 0 {
+			parseQueue = DefaultParseQueue
+		}
+		if writeQueue <= 0 {
+			writeQueue = DefaultWriteQueue
+		}
+		p.parse = make(chan *pipelineJob, parseQueue)
+		p.write = make(chan *pipelineJob, writeQueue)
+		p.done = make(chan struct{})
+		p.stopping = make(chan struct{})
+		p.drained = make(chan struct{})
+
+		metrics.Set("parse_queue", expvar.Func(func() any { return len(p.parse) }))
+		metrics.Set("write_queue", expvar.Func(func() any { return len(p.write) }))
+		metrics.Set("parsed", &p.parsed)
+		metrics.Set("written", &p.wrote)
+		metrics.Set("write_retries", &p.retry)
+		metrics.Set("write_failures", &p.failed)
+	})
+}
+
+// Submit queues an update, blocking while the queues are full. Once Submit
+// returns the update's write is retried until it succeeds or the pipeline
+// stops.
+func (p *Pipeline) Submit(update *pb.SubscribeUpdate) error {
+	return p.SubmitThen(update, nil)
+}
+
+// SubmitThen is Submit with a callback run after the update was written.
+// Callbacks run on the writer in submission order.
+func (p *Pipeline) SubmitThen(update *pb.SubscribeUpdate, written func()) error {
+	p.init()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Drain closes the queues after stopping, so check it before sending
+	select {
+	case <-p.stopping:
+		return ErrPipelineStopped
+	default:
+	}
+
+	job := &pipelineJob{update: update, done: make(chan struct{}), written: written}
+	// Queue for writing first so the writer sees jobs in submission order
+	select {
+	case p.write <- job:
+	case <-p.stopping:
+		return ErrPipelineStopped
+	case <-p.done:
+		return ErrPipelineStopped
+	}
+	// The job is queued for writing, so it must reach a parse worker
+	select {
+	case p.parse <- job:
+	case <-p.done:
+		return ErrPipelineStopped
+	}
+	return nil
+}
+
+// TrySubmit queues an update only if both queues have room, reporting
+// whether it did
+func (p *Pipeline) TrySubmit(update *pb.SubscribeUpdate) bool {
+	p.init()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.stopping:
+		return false
+	case <-p.done:
+		return false
+	default:
+	}
+	// Only Submit adds to the queues and it holds p.mu, so room cannot vanish
+	if len(p.write) == cap(p.write) || len(p.parse) == cap(p.parse) {
+		return false
+	}
+	job := &pipelineJob{update: update, done: make(chan struct{})}
+	p.write <- job
+	p.parse <- job
+	return true
+}
+
+// Handle adapts Submit to the update handler signature
+func (p *Pipeline) Handle(update *pb.SubscribeUpdate) error {
+	return p.Submit(update)
+}
+
+// Stop stops accepting updates. Submitters blocked on full queues return
+// ErrPipelineStopped, while updates already queued are still written.
+func (p *Pipeline) Stop() {
+	p.init()
+	p.stopOnce.Do(func() { close(p.stopping) })
+}
+
+// Drain stops accepting updates and waits until everything already queued
+// is parsed and written, or until ctx is done
+func (p *Pipeline) Drain(ctx context.Context) error {
+	p.Stop()
+	p.closeOnce.Do(func() {
+		// Submitters woken by stopping release p.mu, after which nobody sends
+		p.mu.Lock()
+		close(p.write)
+		close(p.parse)
+		p.mu.Unlock()
+	})
+
+	select {
+	case <-p.drained:
+		return nil
+	case <-p.done:
+		return p.Err()
+	case <-ctx.Done():
+		return fmt.Errorf("%d updates left unwritten: %v", len(p.write), ctx.Err())
+	}
+}
+
+// Done returns a channel closed once the pipeline stopped writing
+func (p *Pipeline) Done() <-chan struct{} {
+	p.init()
+	return p.done
+}
+
+// Err returns why the pipeline stopped writing early, or nil
+func (p *Pipeline) Err() error {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	return p.err
+}
+
+// parseWorker prepares the writes of queued updates
+func (p *Pipeline) parseWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-p.parse:
+			if !ok {
+				return
+			}
+			job.write = p.Prepare(job.update)
+			p.parsed.Add(1)
+			close(job.done)
+		}
+	}
+}
+
+// writer applies prepared writes in submission order, retrying failed ones.
+// It stops early when it gives up on an update that must be written.
+func (p *Pipeline) writer(ctx context.Context) {
+	for {
+		var job *pipelineJob
+		select {
+		case <-ctx.Done():
+			return
+		case next, ok := <-p.write:
+			if !ok {
+				close(p.drained)
+				return
+			}
+			job = next
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-job.done:
+		}
+
+		err := p.apply(ctx, job)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.failed.Add(1)
+			if p.MustWrite != nil && p.MustWrite(job.update) {
+				p.errMu.Lock()
+				p.err = fmt.Errorf("gave up on write of %T: %v", job.update.GetUpdateOneof(), err)
+				p.errMu.Unlock()
+				return
+			}
+			log.Printf("Giving up on write of %T: %v", job.update.GetUpdateOneof(), err)
+		} else {
+			p.wrote.Add(1)
+		}
+		if job.written != nil {
+			job.written()
+		}
+	}
+}
+
+// apply runs a job's write until it succeeds, fails WriteAttempts times
+// while the database is healthy, or ctx is done
+func (p *Pipeline) apply(ctx context.Context, job *pipelineJob) error {
+	minBackoff, maxBackoff := p.MinBackoff, p.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = DefaultMaxBackoff
+	}
+	attempts := p.WriteAttempts
+	if attempts <= 0 {
+		attempts = DefaultWriteAttempts
+	}
+
+	backoff := minBackoff
+	for failures := 0; ; {
+		err := job.write()
+		if err == nil {
+			return nil
+		}
+		if p.Healthy == nil || p.Healthy() == nil {
+			failures++
+			if failures >= attempts {
+				return fmt.Errorf("failed %d times: %v", failures, err)
+			}
+		}
+		p.retry.Add(1)
+		log.Printf("Failed to write update, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
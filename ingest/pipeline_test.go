@@ -0,0 +1,269 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	pb "goblockstore/proto"
+)
+
+// noopPrepare prepares a write that always succeeds
+func noopPrepare(*pb.SubscribeUpdate) func() error {
+	return func() error { return nil }
+}
+
+func TestPipelineWritesInOrder(t *testing.T) {
+	const updates = 200
+	const broken = 17
+
+	var mu sync.Mutex
+	var wrote, callbacks []uint64
+	p := &Pipeline{
+		// Parse out of order, and fail one write for good
+		Prepare: func(update *pb.SubscribeUpdate) func() error {
+			slot := update.GetSlot().Slot
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+			return func() error {
+				if slot == broken {
+					return errors.New("bad row")
+				}
+				mu.Lock()
+				wrote = append(wrote, slot)
+				mu.Unlock()
+				return nil
+			}
+		},
+		ParseWorkers:  8,
+		ParseQueue:    4,
+		WriteQueue:    16,
+		MinBackoff:    time.Microsecond,
+		MaxBackoff:    time.Microsecond,
+		WriteAttempts: 2,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	for slot := uint64(0); slot < updates; slot++ {
+		err := p.SubmitThen(slotUpdate(slot), func() {
+			mu.Lock()
+			callbacks = append(callbacks, slot)
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatalf("Submit %d: %v", slot, err)
+		}
+	}
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer drainCancel()
+	if err := p.Drain(drainCtx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if err := p.Submit(slotUpdate(updates)); !errors.Is(err, ErrPipelineStopped) {
+		t.Errorf("Submit after Drain: %v, want ErrPipelineStopped", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(wrote) != updates-1 || len(callbacks) != updates {
+		t.Fatalf("%d writes and %d callbacks, want %d and %d", len(wrote), len(callbacks), updates-1, updates)
+	}
+	for i, slot := range callbacks {
+		if slot != uint64(i) {
+			t.Fatalf("callback %d for slot %d", i, slot)
+		}
+	}
+	for i := 1; i < len(wrote); i++ {
+		if wrote[i] <= wrote[i-1] {
+			t.Fatalf("slot %d written after %d", wrote[i], wrote[i-1])
+		}
+	}
+	if got := p.failed.Value(); got != 1 {
+		t.Errorf("%d failures, want 1", got)
+	}
+}
+
+func TestPipelineWriteAttempts(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int // writes failing before one succeeds, -1 for all
+		unhealthy int // health checks reporting the database down
+		calls     int
+		giveUp    bool
+	}{
+		{"first try", 3, 0, 0, 1, false},
+		{"recovers", 3, 2, 0, 3, false},
+		{"gives up", 3, -1, 0, 3, true},
+		{"default attempts", 0, -1, 0, DefaultWriteAttempts, true},
+		{"outage not counted", 2, -1, 4, 6, true},
+		{"recovers after outage", 2, 5, 4, 6, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, checks int
+			p := &Pipeline{
+				MinBackoff:    time.Microsecond,
+				MaxBackoff:    time.Microsecond,
+				WriteAttempts: tt.attempts,
+				Healthy: func() error {
+					checks++
+					if checks <= tt.unhealthy {
+						return errors.New("database down")
+					}
+					return nil
+				},
+			}
+			job := &pipelineJob{write: func() error {
+				calls++
+				if tt.failures < 0 || calls <= tt.failures {
+					return errors.New("write failed")
+				}
+				return nil
+			}}
+
+			err := p.apply(context.Background(), job)
+			if (err != nil) != tt.giveUp {
+				t.Errorf("apply: %v, want give up %v", err, tt.giveUp)
+			}
+			if calls != tt.calls {
+				t.Errorf("%d calls, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestPipelineSubmitDuringDrain(t *testing.T) {
+	for run := 0; run < 50; run++ {
+		p := &Pipeline{Prepare: noopPrepare, ParseWorkers: 2, ParseQueue: 1, WriteQueue: 1}
+		ctx, cancel := context.WithCancel(context.Background())
+		go p.Run(ctx)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					err := p.Submit(&pb.SubscribeUpdate{})
+					if errors.Is(err, ErrPipelineStopped) {
+						return
+					}
+					if err != nil {
+						t.Errorf("Submit: %v", err)
+						return
+					}
+				}
+			}()
+		}
+
+		time.Sleep(time.Millisecond)
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := p.Drain(drainCtx); err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+		drainCancel()
+		wg.Wait()
+		cancel()
+	}
+}
+
+func TestPipelineStopReleasesBlockedSubmit(t *testing.T) {
+	// Writes hang as during a database outage
+	stalled := make(chan struct{})
+	defer close(stalled)
+	p := &Pipeline{
+		Prepare: func(*pb.SubscribeUpdate) func() error {
+			return func() error {
+				<-stalled
+				return nil
+			}
+		},
+		ParseWorkers: 1,
+		ParseQueue:   1,
+		WriteQueue:   1,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	submitted := make(chan error)
+	go func() {
+		for {
+			if err := p.Submit(&pb.SubscribeUpdate{}); err != nil {
+				submitted <- err
+				return
+			}
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	p.Stop()
+	select {
+	case err := <-submitted:
+		if !errors.Is(err, ErrPipelineStopped) {
+			t.Errorf("Submit: %v, want ErrPipelineStopped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Submit still blocked after Stop")
+	}
+}
+
+func TestPipelineStopsOnMustWrite(t *testing.T) {
+	var mu sync.Mutex
+	var wrote []uint64
+	p := &Pipeline{
+		Prepare: func(update *pb.SubscribeUpdate) func() error {
+			slot := update.GetSlot().Slot
+			return func() error {
+				if slot == 2 {
+					return errors.New("bad row")
+				}
+				mu.Lock()
+				wrote = append(wrote, slot)
+				mu.Unlock()
+				return nil
+			}
+		},
+		MinBackoff:    time.Microsecond,
+		MaxBackoff:    time.Microsecond,
+		WriteAttempts: 1,
+		MustWrite:     func(update *pb.SubscribeUpdate) bool { return update.GetSlot().Slot == 2 },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	for slot := uint64(1); slot <= 3; slot++ {
+		// The pipeline may already have stopped by the last one
+		if err := p.Submit(slotUpdate(slot)); err != nil && !errors.Is(err, ErrPipelineStopped) {
+			t.Fatalf("Submit %d: %v", slot, err)
+		}
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline kept running after giving up on a required write")
+	}
+
+	if p.Err() == nil {
+		t.Error("Err is nil after giving up on a required write")
+	}
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer drainCancel()
+	if err := p.Drain(drainCtx); err == nil {
+		t.Error("Drain succeeded after giving up on a required write")
+	}
+	if p.TrySubmit(slotUpdate(4)) {
+		t.Error("TrySubmit accepted an update after the pipeline stopped")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(wrote) != 1 || wrote[0] != 1 {
+		t.Errorf("wrote %v, want only [1]", wrote)
+	}
+}
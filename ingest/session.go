@@ -0,0 +1,23 @@
+package ingest
+
+import (
+	"sync"
+
+	pb "goblockstore/proto"
+
+	"google.golang.org/grpc"
+)
+
+// Session is a single Geyser Subscribe stream. Sends are serialized so the
+// subscription manager and the receive loop can share the stream.
+type Session struct {
+	mu     sync.Mutex
+	stream grpc.BidiStreamingClient[pb.SubscribeRequest, pb.SubscribeUpdate]
+}
+
+// Send writes a request to the stream
+func (s *Session) Send(req *pb.SubscribeRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.Send(req)
+}
@@ -0,0 +1,183 @@
+package ingest
+
+import (
+	"fmt"
+	"sync"
+
+	pb "goblockstore/proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Filter kinds that can be changed at runtime
+const (
+	KindAccounts           = "accounts"
+	KindTransactions       = "transactions"
+	KindTransactionsStatus = "transactions_status"
+	KindSlots              = "slots"
+)
+
+// Subscriptions owns the filters of the Geyser subscription. Filters added or
+// removed at runtime are merged with the base request and sent over the live
+// streams, which Geyser applies without reconnecting. Every live session,
+// one per endpoint, receives the same filters.
+type Subscriptions struct {
+	base func() *pb.SubscribeRequest
+
+	mu                 sync.Mutex
+	sessions           map[*Session]struct{}
+	accounts           map[string]*pb.SubscribeRequestFilterAccounts
+	transactions       map[string]*pb.SubscribeRequestFilterTransactions
+	transactionsStatus map[string]*pb.SubscribeRequestFilterTransactions
+	slots              map[string]*pb.SubscribeRequestFilterSlots
+}
+
+// NewSubscriptions creates a manager on top of the static filters built by base
+func NewSubscriptions(base func() *pb.SubscribeRequest) *Subscriptions {
+	return &Subscriptions{
+		base:               base,
+		sessions:           make(map[*Session]struct{}),
+		accounts:           make(map[string]*pb.SubscribeRequestFilterAccounts),
+		transactions:       make(map[string]*pb.SubscribeRequestFilterTransactions),
+		transactionsStatus: make(map[string]*pb.SubscribeRequestFilterTransactions),
+		slots:              make(map[string]*pb.SubscribeRequestFilterSlots),
+	}
+}
+
+// Request returns the base request merged with the runtime filters
+func (s *Subscriptions) Request() *pb.SubscribeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.request()
+}
+
+// SetAccounts adds or replaces a named account filter
+func (s *Subscriptions) SetAccounts(name string, filter *pb.SubscribeRequestFilterAccounts) error {
+	return s.update(func() { s.accounts[name] = filter })
+}
+
+// SetTransactions adds or replaces a named transaction filter
+func (s *Subscriptions) SetTransactions(name string, filter *pb.SubscribeRequestFilterTransactions) error {
+	return s.update(func() { s.transactions[name] = filter })
+}
+
+// SetTransactionsStatus adds or replaces a named transaction status filter
+func (s *Subscriptions) SetTransactionsStatus(name string, filter *pb.SubscribeRequestFilterTransactions) error {
+	return s.update(func() { s.transactionsStatus[name] = filter })
+}
+
+// SetSlots adds or replaces a named slot filter
+func (s *Subscriptions) SetSlots(name string, filter *pb.SubscribeRequestFilterSlots) error {
+	return s.update(func() { s.slots[name] = filter })
+}
+
+// Refresh rebuilds the base request and pushes it to every live session, for
+// base filters that follow external state
+func (s *Subscriptions) Refresh() error {
+	return s.update(func() {})
+}
+
+// Remove deletes a runtime filter of the given kind. Filters from the base
+// request cannot be removed.
+func (s *Subscriptions) Remove(kind, name string) error {
+	switch kind {
+	case KindAccounts, KindTransactions, KindTransactionsStatus, KindSlots:
+	default:
+		return fmt.Errorf("unknown filter kind %q", kind)
+	}
+
+	var found bool
+	err := s.update(func() {
+		switch kind {
+		case KindAccounts:
+			_, found = s.accounts[name]
+			delete(s.accounts, name)
+		case KindTransactions:
+			_, found = s.transactions[name]
+			delete(s.transactions, name)
+		case KindTransactionsStatus:
+			_, found = s.transactionsStatus[name]
+			delete(s.transactionsStatus, name)
+		case KindSlots:
+			_, found = s.slots[name]
+			delete(s.slots, name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no runtime %s filter named %q", kind, name)
+	}
+	return nil
+}
+
+// start attaches a new session and sends it the initial request, starting at
+// fromSlot when set. Holding the lock keeps concurrent changes from being lost.
+func (s *Subscriptions) start(session *Session, fromSlot *uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req := s.request()
+	req.FromSlot = fromSlot
+	if err := session.Send(req); err != nil {
+		return err
+	}
+	s.sessions[session] = struct{}{}
+	return nil
+}
+
+// stop detaches session once it has ended
+func (s *Subscriptions) stop(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, session)
+}
+
+// update applies a change and pushes the merged request to every live
+// session. Sessions that start later pick the change up on their own.
+func (s *Subscriptions) update(change func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change()
+	var failed error
+	for session := range s.sessions {
+		if err := session.Send(s.request()); err != nil {
+			failed = fmt.Errorf("error sending updated subscription: %v", err)
+		}
+	}
+	return failed
+}
+
+// request merges the runtime filters into a fresh base request. s.mu must be held.
+func (s *Subscriptions) request() *pb.SubscribeRequest {
+	req := s.base()
+	req.FromSlot = nil
+
+	for name, filter := range s.accounts {
+		if req.Accounts == nil {
+			req.Accounts = make(map[string]*pb.SubscribeRequestFilterAccounts)
+		}
+		req.Accounts[name] = proto.Clone(filter).(*pb.SubscribeRequestFilterAccounts)
+	}
+	for name, filter := range s.transactions {
+		if req.Transactions == nil {
+			req.Transactions = make(map[string]*pb.SubscribeRequestFilterTransactions)
+		}
+		req.Transactions[name] = proto.Clone(filter).(*pb.SubscribeRequestFilterTransactions)
+	}
+	for name, filter := range s.transactionsStatus {
+		if req.TransactionsStatus == nil {
+			req.TransactionsStatus = make(map[string]*pb.SubscribeRequestFilterTransactions)
+		}
+		req.TransactionsStatus[name] = proto.Clone(filter).(*pb.SubscribeRequestFilterTransactions)
+	}
+	for name, filter := range s.slots {
+		if req.Slots == nil {
+			req.Slots = make(map[string]*pb.SubscribeRequestFilterSlots)
+		}
+		req.Slots[name] = proto.Clone(filter).(*pb.SubscribeRequestFilterSlots)
+	}
+	return req
+}
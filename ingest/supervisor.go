@@ -0,0 +1,241 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	pb "goblockstore/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Default reconnect backoff bounds
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// ErrCredentialsRotated ends a session that authenticated with a token that
+// has since been replaced
+var ErrCredentialsRotated = errors.New("geyser credentials rotated")
+
+// ErrReplayUnavailable ends a session whose server cannot replay from the
+// requested slot, as it is older than the server keeps
+var ErrReplayUnavailable = errors.New("geyser server cannot replay from the requested slot")
+
+// Supervisor keeps a Geyser subscription alive. Whenever the stream fails it
+// redials with exponential backoff and resubscribes from the slot after the
+// highest one already persisted, so a reconnect never loses or duplicates blocks.
+// A slot older than the server can replay is skipped instead: the session
+// resubscribes live and the slots in between are left to the backfiller.
+type Supervisor struct {
+	// Name identifies the endpoint in logs
+	Name string
+	// Dial opens a new gRPC connection to the Geyser endpoint
+	Dial func(ctx context.Context) (*grpc.ClientConn, error)
+	// Request builds the subscription request sent on every (re)connect
+	Request func() *pb.SubscribeRequest
+	// Subscriptions, when set, replaces Request and receives every session so
+	// filters can be changed at runtime
+	Subscriptions *Subscriptions
+	// LastSlot returns the highest slot already committed, if any
+	LastSlot func() (uint64, bool, error)
+	// Handle processes a single update. A returned error ends the current
+	// session and triggers a resubscribe from the last committed slot.
+	Handle func(update *pb.SubscribeUpdate) error
+
+	// Rotated, when set, returns a channel closed once the credentials change.
+	// The stream is then re-established with the new token right away.
+	Rotated func() <-chan struct{}
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// PingInterval is how often the client pings the server, and
+	// MaxMissedPongs how many intervals without receiving anything end the
+	// session. Time spent in Handle does not count.
+	PingInterval   time.Duration
+	MaxMissedPongs int
+
+	rtt atomic.Int64
+	// live skips from_slot on the next session, after the server rejected it
+	live bool
+}
+
+// RTT returns the round-trip time measured by the last answered ping
+func (s *Supervisor) RTT() time.Duration {
+	return time.Duration(s.rtt.Load())
+}
+
+// Run supervises the subscription until ctx is cancelled
+func (s *Supervisor) Run(ctx context.Context) error {
+	minBackoff, maxBackoff := s.MinBackoff, s.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = DefaultMaxBackoff
+	}
+
+	backoff := minBackoff
+	for {
+		received, err := s.runSession(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received {
+			backoff = minBackoff
+		}
+		if errors.Is(err, ErrCredentialsRotated) {
+			log.Printf("Geyser credentials rotated, resubscribing%s", s.label())
+			continue
+		}
+		if errors.Is(err, ErrReplayUnavailable) {
+			s.live = true
+			continue
+		}
+		log.Printf("Geyser session%s ended: %v, reconnecting in %v", s.label(), err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runSession dials, subscribes and consumes updates until the stream or the
+// handler fails. It reports whether at least one update was handled.
+func (s *Supervisor) runSession(ctx context.Context) (bool, error) {
+	// Watch for rotations from before dialing so none can slip by unnoticed
+	var rotated <-chan struct{}
+	if s.Rotated != nil {
+		rotated = s.Rotated()
+	}
+
+	conn, err := s.Dial(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error dialing: %v", err)
+	}
+	defer conn.Close()
+
+	var fromSlot *uint64
+	lastSlot, ok, err := s.LastSlot()
+	if err != nil {
+		return false, fmt.Errorf("error loading last committed slot: %v", err)
+	}
+	switch {
+	case ok && s.live:
+		// The slots in between are left as a gap on the parent chain, which
+		// the backfiller finds and fills
+		log.Printf("Slot %d is no longer replayable%s, subscribing live and leaving a gap after slot %d for backfill", lastSlot+1, s.label(), lastSlot)
+	case ok:
+		next := lastSlot + 1
+		fromSlot = &next
+		log.Printf("Resuming subscription%s from slot %d", s.label(), next)
+	}
+	s.live = false
+
+	sessionCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stream, err := pb.NewGeyserClient(conn).Subscribe(sessionCtx)
+	if err != nil {
+		return false, fmt.Errorf("error subscribing: %v", err)
+	}
+	session := &Session{stream: stream}
+
+	if s.Subscriptions != nil {
+		err = s.Subscriptions.start(session, fromSlot)
+		defer s.Subscriptions.stop(session)
+	} else {
+		req := s.Request()
+		req.FromSlot = fromSlot
+		err = session.Send(req)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error sending subscription request: %v", err)
+	}
+
+	keepalive := newKeepalive(session, s.PingInterval, s.MaxMissedPongs, func(rtt time.Duration) {
+		s.rtt.Store(int64(rtt))
+	})
+	go keepalive.run(sessionCtx, cancel)
+
+	if rotated != nil {
+		go func() {
+			select {
+			case <-rotated:
+				cancel(ErrCredentialsRotated)
+			case <-sessionCtx.Done():
+			}
+		}()
+	}
+
+	received, first := false, true
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			if cause := context.Cause(sessionCtx); cause != nil && ctx.Err() == nil {
+				if errors.Is(cause, ErrCredentialsRotated) {
+					return received, cause
+				}
+				err = cause
+			}
+			if first && fromSlot != nil && replayUnavailable(err) {
+				log.Printf("Geyser server%s rejected from_slot %d: %v", s.label(), *fromSlot, err)
+				return received, ErrReplayUnavailable
+			}
+			return received, fmt.Errorf("error receiving update: %v", err)
+		}
+		first = false
+		keepalive.received()
+
+		// Answer keepalive traffic here so load balancers see a live stream
+		if update.GetPing() != nil {
+			if err := keepalive.replyToServer(); err != nil {
+				return received, fmt.Errorf("error answering ping: %v", err)
+			}
+			continue
+		}
+		if pong := update.GetPong(); pong != nil {
+			keepalive.pong(pong.Id)
+			continue
+		}
+
+		keepalive.handling(true)
+		err = s.Handle(update)
+		keepalive.handling(false)
+		if err != nil {
+			return received, fmt.Errorf("error handling update: %v", err)
+		}
+		received = true
+	}
+}
+
+// replayUnavailable reports whether err is the server rejecting a from_slot it
+// no longer holds
+func replayUnavailable(err error) bool {
+	st, ok := status.FromError(err)
+	return ok && st.Code() == codes.InvalidArgument && strings.Contains(strings.ToLower(st.Message()), "slot")
+}
+
+// label formats the endpoint name for log messages
+func (s *Supervisor) label() string {
+	if s.Name == "" {
+		return ""
+	}
+	return " to " + s.Name
+}
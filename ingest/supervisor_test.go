@@ -0,0 +1,28 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplayUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"from_slot too old", status.Error(codes.InvalidArgument, "broadcast from_slot 100 is not available, last available: 200"), true},
+		{"other invalid argument", status.Error(codes.InvalidArgument, "invalid commitment"), false},
+		{"unavailable", status.Error(codes.Unavailable, "slot 100 not ready"), false},
+		{"not a status", fmt.Errorf("slot %d: %v", 100, errors.New("invalid argument")), false},
+	}
+	for _, tt := range tests {
+		if got := replayUnavailable(tt.err); got != tt.want {
+			t.Errorf("%s: replayUnavailable %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,132 +2,551 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"goblockstore/accounts"
+	"goblockstore/backfill"
+	"goblockstore/connect"
 	"goblockstore/db"
+	"goblockstore/fork"
+	"goblockstore/ingest"
 	"goblockstore/parser"
 	pb "goblockstore/proto"
+	"goblockstore/sigtracker"
+	"goblockstore/spool"
+	"goblockstore/subscription"
+	"goblockstore/txstream"
 
 	"github.com/joho/godotenv"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/encoding/gzip"
-	"google.golang.org/grpc/keepalive"
+	"google.golang.org/protobuf/proto"
 )
 
-// QuickNode endpoints consist of two crucial components: the endpoint name and the corresponding token
+// A QuickNode endpoint consists of the endpoint name and the corresponding token
 // For eg: QN Endpoint: https://docs-demo.solana-mainnet.quiknode.pro/abcde123456789
 // endpoint will be: docs-demo.solana-mainnet.quiknode.pro:10000  {10000 is the port number for gRPC}
 // token will be : abcde123456789
+// Other providers and local servers take a full URL, see connect.Config.
 
-var (
-	endpoint string
-	token    string
+// primary is the first configured endpoint, used for unary calls
+var primary connect.Config
+
+// Ingestion modes selected by INGEST_MODE
+const (
+	// modeBlocks ingests full blocks with their transactions
+	modeBlocks = "blocks"
+	// modeBlocksMeta ingests only block headers and rewards
+	modeBlocksMeta = "blocks_meta"
+	// modeTransactions ingests transactions as they execute and reconciles
+	// them with block headers as those arrive
+	modeTransactions = "transactions"
 )
 
-var kacp = keepalive.ClientParameters{
-	Time:                10 * time.Second,
-	Timeout:             time.Second,
-	PermitWithoutStream: true,
+// modeKinds lists the subscription filter kinds each mode stores. Updates of
+// other kinds would be received and dropped.
+var modeKinds = map[string][]string{
+	modeBlocks:       {"blocks", "slots", "accounts"},
+	modeBlocksMeta:   {"blocks_meta", "slots", "accounts"},
+	modeTransactions: {"transactions", "blocks_meta", "slots", "accounts"},
 }
 
-// tokenAuth implements the credentials.PerRPCCredentials interface
-type tokenAuth struct {
-	token string
-}
+// Exit codes reporting how the process stopped
+const (
+	exitOK = 0
+	// exitFailure is a startup error or a shutdown caused by a failure
+	exitFailure = 1
+	// exitDrainIncomplete is a shutdown that left queued updates unwritten
+	exitDrainIncomplete = 2
+)
 
-func (t tokenAuth) GetRequestMetadata(ctx context.Context, in ...string) (map[string]string, error) {
-	return map[string]string{"x-token": t.token}, nil
-}
+// defaultShutdownTimeout bounds the shutdown when SHUTDOWN_TIMEOUT is unset
+const defaultShutdownTimeout = 30 * time.Second
 
-func (tokenAuth) RequireTransportSecurity() bool {
-	return true
-}
+// defaultPipeline names the checkpoint used when INGEST_PIPELINE is unset
+const defaultPipeline = "blocks"
 
 func main() {
+	os.Exit(run())
+}
+
+// run ingests until SIGINT or SIGTERM, then drains the pipeline and returns
+// the process exit code
+func run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Background services cancel with their error to trigger a shutdown
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Printf("Error loading .env file")
+		return exitFailure
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		return doctor(os.Args[2:])
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		shutdownTimeout, err = time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid SHUTDOWN_TIMEOUT: %v", err)
+			return exitFailure
+		}
 	}
 
 	// Connect to SingleStore
 	dbConn, err := db.Connect()
 	if err != nil {
-		log.Fatalf("Failed to connect to SingleStore: %v", err)
+		log.Printf("Failed to connect to SingleStore: %v", err)
+		return exitFailure
 	}
 	defer dbConn.Close()
 
 	// Connect to Solana gRPC
-	endpoint = os.Getenv("QUICKNODE_ENDPOINT")
-	token = os.Getenv("QUICKNODE_TOKEN")
-	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})),
-		grpc.WithKeepaliveParams(kacp),
-		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(1024*1024*1024), grpc.UseCompressor(gzip.Name)),
-		grpc.WithPerRPCCredentials(tokenAuth{token: token}),
+	shared, connections, err := loadConnections()
+	if err != nil {
+		log.Printf("Invalid connection settings: %v", err)
+		return exitFailure
+	}
+	primary = connections[0]
+	if shared.Credentials != nil {
+		go shared.Credentials.Run(ctx)
 	}
 
-	conn, err := grpc.Dial(endpoint, opts...)
+	pipeline := os.Getenv("INGEST_PIPELINE")
+	if pipeline == "" {
+		pipeline = defaultPipeline
+	}
+	commitment, err := subscription.ParseCommitment(os.Getenv("GEYSER_COMMITMENT"))
 	if err != nil {
-		log.Fatalf("Failed to connect: %v", err)
+		log.Printf("Invalid GEYSER_COMMITMENT: %v", err)
+		return exitFailure
 	}
-	defer conn.Close()
 
-	client := pb.NewGeyserClient(conn)
+	mode := os.Getenv("INGEST_MODE")
+	switch mode {
+	case "":
+		mode = modeBlocks
+	case modeBlocks, modeBlocksMeta, modeTransactions:
+	default:
+		log.Printf("Invalid INGEST_MODE %q, expected %s, %s or %s", mode, modeBlocks, modeBlocksMeta, modeTransactions)
+		return exitFailure
+	}
 
-	commitment := pb.CommitmentLevel_FINALIZED
-	subReq := &pb.SubscribeRequest{
-		Commitment: &commitment,
-		Blocks: map[string]*pb.SubscribeRequestFilterBlocks{
-			"blocks": {},
-		},
+	// Optionally take the subscription filters from a config file instead of
+	// deriving them from INGEST_MODE and ACCOUNTS_*. The mode still decides
+	// which kinds of filters the file may declare.
+	var configured *pb.SubscribeRequest
+	var configFromSlot *uint64
+	if path := os.Getenv("SUBSCRIPTION_CONFIG"); path != "" {
+		config, err := subscription.Load(path)
+		if err == nil {
+			err = config.Restrict(modeKinds[mode]...)
+		}
+		if err == nil {
+			configured, err = config.Compile()
+		}
+		if err != nil {
+			log.Printf("Invalid SUBSCRIPTION_CONFIG for %s mode: %v", mode, err)
+			return exitFailure
+		}
+		// Slot updates drive the slot timeline and the fork tracker in every mode
+		if len(configured.Slots) == 0 {
+			configured.Slots = map[string]*pb.SubscribeRequestFilterSlots{"slots": {}}
+		}
+		if config.Commitment != "" {
+			commitment = configured.GetCommitment()
+		}
+		configFromSlot = configured.FromSlot
 	}
 
-	stream, err := client.Subscribe(context.Background())
+	accountFilter, err := accounts.FilterFromEnv()
 	if err != nil {
-		log.Fatalf("Failed to subscribe to yellowstone: %v", err)
-		return
+		log.Printf("Invalid account filter: %v", err)
+		return exitFailure
+	}
+
+	idx := &indexer{
+		db:     dbConn,
+		cursor: &parser.Checkpoint{Pipeline: pipeline, Commitment: commitment.String()},
+	}
+	if mode == modeTransactions {
+		idx.txs = txstream.NewReconciler(dbConn)
+		lastSlot, _, err := parser.ResumeSlot(dbConn, pipeline)
+		if err == nil {
+			err = idx.txs.Resume(lastSlot)
+		}
+		if err != nil {
+			log.Printf("Failed to resume streamed transactions: %v", err)
+			return exitFailure
+		}
+	}
+	if os.Getenv("ACCOUNTS_BOOTSTRAP") == "true" {
+		idx.bootstrap = accounts.NewBootstrapper(dbConn)
+	}
+	// Below finalized, blocks can land on forks that are later abandoned
+	if commitment != pb.CommitmentLevel_FINALIZED {
+		idx.forks = fork.NewTracker(dbConn, pipeline)
 	}
 
-	if err = stream.Send(subReq); err != nil {
-		log.Fatalf("Failed to send subscription request: %v", err)
-		return
+	includeEntries := true
+	includeVotes := false
+	subscriptions := ingest.NewSubscriptions(func() *pb.SubscribeRequest {
+		if configured != nil {
+			req := proto.Clone(configured).(*pb.SubscribeRequest)
+			req.Commitment = &commitment
+			if filters := signatureFilters(idx.signatures); filters != nil {
+				if req.TransactionsStatus == nil {
+					req.TransactionsStatus = make(map[string]*pb.SubscribeRequestFilterTransactions)
+				}
+				for name, filter := range filters {
+					req.TransactionsStatus[name] = filter
+				}
+			}
+			return req
+		}
+
+		req := &pb.SubscribeRequest{
+			Commitment: &commitment,
+			Slots: map[string]*pb.SubscribeRequestFilterSlots{
+				"slots": {},
+			},
+		}
+		if mode == modeBlocksMeta || mode == modeTransactions {
+			req.BlocksMeta = map[string]*pb.SubscribeRequestFilterBlocksMeta{
+				"blocks_meta": {},
+			}
+		}
+		if mode == modeTransactions {
+			req.Transactions = map[string]*pb.SubscribeRequestFilterTransactions{
+				"transactions": {Vote: &includeVotes},
+			}
+		}
+		req.TransactionsStatus = signatureFilters(idx.signatures)
+		if mode == modeBlocks {
+			req.Blocks = map[string]*pb.SubscribeRequestFilterBlocks{
+				"blocks": {IncludeEntries: &includeEntries},
+			}
+		}
+		if accountFilter != nil {
+			// Validated by FilterFromEnv
+			filter, _ := accountFilter.ToProto()
+			req.Accounts = map[string]*pb.SubscribeRequestFilterAccounts{
+				"accounts": filter,
+			}
+		}
+		return req
+	})
+
+	// Parse in a worker pool and write in stream order, so slow writes do not
+	// hold up receiving
+	stages := &ingest.Pipeline{
+		Prepare:   idx.prepareUpdate,
+		MustWrite: advancesCheckpoint,
+		Healthy: func() error {
+			pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return dbConn.PingContext(pingCtx)
+		},
+	}
+	for name, setting := range map[string]*int{
+		"INGEST_PARSE_WORKERS":  &stages.ParseWorkers,
+		"INGEST_PARSE_QUEUE":    &stages.ParseQueue,
+		"INGEST_WRITE_QUEUE":    &stages.WriteQueue,
+		"INGEST_WRITE_ATTEMPTS": &stages.WriteAttempts,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				log.Printf("Invalid %s: %v", name, err)
+				return exitFailure
+			}
+			*setting = n
+		}
 	}
+	// The pipeline outlives ctx so queued updates can drain on shutdown
+	stagesCtx, stopStages := context.WithCancel(context.Background())
+	defer stopStages()
+	go stages.Run(stagesCtx)
+	// A block that cannot be written stops ingestion, so the checkpoint
+	// never moves past it and a restart retries it
+	go func() {
+		<-stages.Done()
+		if err := stages.Err(); err != nil {
+			cancel(fmt.Errorf("ingest pipeline stopped: %v", err))
+		}
+	}()
 
-	log.Println("Listening for blocks...")
-	for {
-		m, err := stream.Recv()
+	// Optionally serve the signature confirmation tracker
+	if addr := os.Getenv("SIGTRACKER_ADDR"); addr != "" {
+		rpcConn, err := dial(ctx)
 		if err != nil {
-			log.Printf("Failed to receive yellowstone message: %v", err)
-			return
+			log.Printf("Failed to connect for signature tracking: %v", err)
+			return exitFailure
 		}
+		defer rpcConn.Close()
 
-		if block := m.GetBlock(); block != nil {
+		idx.signatures = sigtracker.NewTracker(pb.NewGeyserClient(rpcConn), commitment)
+		// Only the pending signatures are subscribed to, so the filters follow them
+		idx.signatures.Changed = func() {
+			if err := subscriptions.Refresh(); err != nil {
+				log.Printf("Failed to update signature subscription: %v", err)
+			}
+		}
+		go idx.signatures.Run(ctx)
+		go func() {
+			log.Printf("Serving signature tracker on %s", addr)
+			if err := http.ListenAndServe(addr, idx.signatures.Handler()); err != nil {
+				cancel(fmt.Errorf("signature tracker server failed: %v", err))
+			}
+		}()
+	}
 
-			startTime := time.Now()
-			// Parse the block
-			parsedBlock, err := parser.ParseBlock(block)
+	// Optionally spool updates to disk while the database falls behind
+	handle := stages.Submit
+	var buffer *spool.Buffer
+	if dir := os.Getenv("SPOOL_DIR"); dir != "" {
+		var maxBytes int64
+		if value := os.Getenv("SPOOL_MAX_BYTES"); value != "" {
+			maxBytes, err = strconv.ParseInt(value, 10, 64)
 			if err != nil {
-				log.Printf("Failed to parse block: %v", err)
-				continue
+				log.Printf("Invalid SPOOL_MAX_BYTES: %v", err)
+				return exitFailure
 			}
-			timeTaken := time.Since(startTime)
-			log.Printf("Time taken to parse block: %v, block number: %d, raw tx len: %d, parsed tx len: %d", timeTaken, block.BlockHeight.BlockHeight, len(block.Transactions), len(parsedBlock.Transactions))
+		}
+		updateSpool, err := spool.Open(dir, maxBytes)
+		if err != nil {
+			log.Printf("Failed to open spool: %v", err)
+			return exitFailure
+		}
+		defer updateSpool.Close()
+		if pending := updateSpool.Pending(); pending > 0 {
+			log.Printf("Draining %d spooled updates", pending)
+		}
+
+		buffer = spool.NewBuffer(updateSpool, stages)
+		go buffer.Run(ctx)
+		handle = buffer.Handle
+	}
 
-			// log.Printf("Parsed block: %v", parsedBlock)
+	// Signature statuses are answered on receipt instead of queueing
+	if idx.signatures != nil {
+		handle = idx.trackStatuses(handle)
+	}
 
-			// Save to database
-			if err := parser.SaveToDatabase(dbConn, parsedBlock); err != nil {
-				log.Printf("Failed to save block to database: %v", err)
-				continue
+	// The first copy of each update from any endpoint wins, except that
+	// account updates only come from the primary endpoint so their write
+	// versions are comparable
+	deduper := ingest.NewDeduper(handle)
+	deduper.AccountsFrom = primary.Target
+	supervisors := make([]*ingest.Supervisor, len(connections))
+	for i := range connections {
+		supervisors[i] = &ingest.Supervisor{
+			Name:          connections[i].Target,
+			Dial:          connections[i].Dial,
+			Subscriptions: subscriptions,
+			LastSlot: func() (uint64, bool, error) {
+				slot, ok, err := parser.ResumeSlot(dbConn, pipeline)
+				// Blocks waiting in the spool were received already, even
+				// when the database is unreachable
+				if buffer != nil {
+					if spooled, spooledOK := buffer.LastSlot(); spooledOK && (err != nil || spooled > slot) {
+						return spooled, true, nil
+					}
+				}
+				// The configured from_slot only applies before anything is stored
+				if err == nil && !ok && configFromSlot != nil && *configFromSlot > 0 {
+					return *configFromSlot - 1, true, nil
+				}
+				return slot, ok, err
+			},
+			Handle: deduper.Handler(connections[i].Target),
+		}
+		if shared.Credentials != nil {
+			supervisors[i].Rotated = shared.Credentials.Changed
+		}
+	}
+
+	if interval := os.Getenv("GEYSER_PING_INTERVAL"); interval != "" {
+		every, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Printf("Invalid GEYSER_PING_INTERVAL: %v", err)
+			return exitFailure
+		}
+		for _, supervisor := range supervisors {
+			supervisor.PingInterval = every
+		}
+	}
+
+	// Optionally serve the admin API for runtime subscription changes
+	if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+		go func() {
+			log.Printf("Serving subscription admin API on %s", addr)
+			if err := http.ListenAndServe(addr, subscriptions.Handler()); err != nil {
+				cancel(fmt.Errorf("admin server failed: %v", err))
 			}
+		}()
+	}
+
+	// Optionally serve the pipeline metrics
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go func() {
+			log.Printf("Serving metrics on %s", addr)
+			if err := http.ListenAndServe(addr, expvar.Handler()); err != nil {
+				cancel(fmt.Errorf("metrics server failed: %v", err))
+			}
+		}()
+	}
+
+	// Optionally scan for chain gaps and backfill them in the background
+	if interval := os.Getenv("BACKFILL_INTERVAL"); interval != "" {
+		every, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Printf("Invalid BACKFILL_INTERVAL: %v", err)
+			return exitFailure
+		}
+		backfiller := &backfill.Backfiller{
+			DB:       dbConn,
+			Source:   &backfill.GeyserSource{Dial: dial},
+			Interval: every,
+		}
+		go backfiller.Run(ctx)
+	}
+
+	if len(supervisors) > 1 {
+		go deduper.LogStats(ctx, time.Minute)
+	}
+
+	// Each endpoint reconnects on its own, so losing one never stalls the others
+	log.Printf("Listening for %s on %d endpoint(s)...", mode, len(supervisors))
+	errs := make(chan error, len(supervisors))
+	for _, supervisor := range supervisors {
+		go func(supervisor *ingest.Supervisor) {
+			errs <- supervisor.Run(ctx)
+		}(supervisor)
+	}
+	<-ctx.Done()
+	// A second signal kills the process instead of waiting for the shutdown
+	stop()
+
+	code := exitOK
+	cause := context.Cause(ctx)
+	log.Printf("Shutting down: %v", cause)
+	if !errors.Is(cause, context.Canceled) {
+		code = exitFailure
+	}
+
+	// SHUTDOWN_TIMEOUT bounds the whole shutdown, not just the drain
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	// Stop intake first, so supervisors blocked on full queues return
+	stages.Stop()
+	for stopped := 0; stopped < len(supervisors) && shutdownCtx.Err() == nil; {
+		select {
+		case <-errs:
+			stopped++
+		case <-shutdownCtx.Done():
+			log.Printf("Timed out waiting for %d endpoint(s) to stop", len(supervisors)-stopped)
+		}
+	}
+
+	// Receiving has stopped; finish what is queued before closing connections
+	if err := stages.Drain(shutdownCtx); err != nil {
+		log.Printf("Shutdown drain incomplete: %v", err)
+		code = exitDrainIncomplete
+	}
+	stopStages()
+
+	if checkpoint, err := parser.LoadCheckpoint(dbConn, pipeline); err != nil {
+		log.Printf("Failed to load final checkpoint: %v", err)
+	} else if checkpoint != nil {
+		log.Printf("Checkpoint for %s at slot %d", pipeline, checkpoint.LastSlot)
+	}
+	return code
+}
+
+// loadConnections reads the shared connection settings and derives one
+// connection per configured endpoint
+func loadConnections() (connect.Config, []connect.Config, error) {
+	shared, err := connect.FromEnv()
+	if err != nil {
+		return connect.Config{}, nil, err
+	}
+
+	// Optionally subscribe to several endpoints at once for redundancy
+	endpoints := []string{os.Getenv("QUICKNODE_ENDPOINT")}
+	tokens := []string{os.Getenv("QUICKNODE_TOKEN")}
+	if list := os.Getenv("GEYSER_ENDPOINTS"); list != "" {
+		endpoints = splitList(list)
+		tokens = splitList(os.Getenv("GEYSER_TOKENS"))
+		if len(tokens) == 0 {
+			// Endpoints without auth
+			tokens = make([]string, len(endpoints))
+		}
+		if len(tokens) != len(endpoints) {
+			return connect.Config{}, nil, fmt.Errorf("GEYSER_TOKENS has %d entries, expected one per endpoint (%d)", len(tokens), len(endpoints))
+		}
+	}
+	// A rotating token stands in for the token of a single endpoint
+	if shared.Credentials != nil && len(endpoints) > 1 {
+		return connect.Config{}, nil, fmt.Errorf("GEYSER_TOKEN_FILE and GEYSER_TOKEN_COMMAND supply a single token, but GEYSER_ENDPOINTS lists %d endpoints", len(endpoints))
+	}
+
+	connections := make([]connect.Config, len(endpoints))
+	for i := range endpoints {
+		connections[i] = shared
+		connections[i].Target, connections[i].Token = endpoints[i], tokens[i]
+		if _, _, err := connections[i].DialOptions(); err != nil {
+			return connect.Config{}, nil, fmt.Errorf("%s: %v", endpoints[i], err)
+		}
+	}
+	return shared, connections, nil
+}
+
+// signatureFilters subscribes to the status of each signature the tracker is
+// waiting on. It returns nil when signatures are not tracked.
+func signatureFilters(tracker *sigtracker.Tracker) map[string]*pb.SubscribeRequestFilterTransactions {
+	if tracker == nil {
+		return nil
+	}
+	pending := tracker.Pending()
+	if len(pending) == 0 {
+		return nil
+	}
+	filters := make(map[string]*pb.SubscribeRequestFilterTransactions, len(pending))
+	for _, signature := range pending {
+		filters["signature:"+signature] = &pb.SubscribeRequestFilterTransactions{Signature: &signature}
+	}
+	return filters
+}
+
+// dial opens a gRPC connection to the primary Geyser endpoint
+func dial(ctx context.Context) (*grpc.ClientConn, error) {
+	return primary.Dial(ctx)
+}
 
-			log.Printf("Successfully processed block %d with %d transactions", block.Slot, len(parsedBlock.Transactions))
-			break
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
 }
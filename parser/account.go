@@ -0,0 +1,229 @@
+package parser
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+// ParseAccountUpdate converts a Geyser account update into our structured format
+func ParseAccountUpdate(update *pb.SubscribeUpdateAccount) (*AccountUpdate, error) {
+	info := update.GetAccount()
+	if info == nil {
+		return nil, fmt.Errorf("account update at slot %d has no account info", update.Slot)
+	}
+
+	now := time.Now()
+	account := &AccountUpdate{
+		Slot:         update.Slot,
+		Pubkey:       base58.Encode(info.Pubkey),
+		Owner:        base58.Encode(info.Owner),
+		Lamports:     info.Lamports,
+		Data:         info.Data,
+		Executable:   info.Executable,
+		RentEpoch:    info.RentEpoch,
+		WriteVersion: info.WriteVersion,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if info.TxnSignature != nil {
+		signature := base58.Encode(info.TxnSignature)
+		account.TxnSignature = &signature
+	}
+	return account, nil
+}
+
+// SaveAccountUpdate appends an account update to the history and moves the
+// latest state forward. The latest state only changes when the update is
+// newer by (slot, write_version), so replayed or reordered updates are harmless.
+func SaveAccountUpdate(db *sql.DB, account *AccountUpdate) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT IGNORE INTO account_updates (
+			slot, pubkey, owner, lamports, data, executable, rent_epoch,
+			write_version, txn_signature, updated_at, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		account.Slot,
+		account.Pubkey,
+		account.Owner,
+		account.Lamports,
+		account.Data,
+		account.Executable,
+		account.RentEpoch,
+		account.WriteVersion,
+		account.TxnSignature,
+		account.UpdatedAt,
+		account.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting account update: %v", err)
+	}
+
+	if err := upsertLatestAccount(tx, "accounts_latest", account); err != nil {
+		return err
+	}
+
+	// Commit transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// upsertLatestAccount writes account into a latest-state table unless the
+// stored row is at the same or a newer (slot, write_version)
+func upsertLatestAccount(tx *sql.Tx, table string, account *AccountUpdate) error {
+	var slot, writeVersion uint64
+	err := tx.QueryRow(fmt.Sprintf(`SELECT slot, write_version FROM %s WHERE pubkey = ? FOR UPDATE`, table),
+		account.Pubkey).Scan(&slot, &writeVersion)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO %s (
+				pubkey, slot, owner, lamports, data, executable, rent_epoch,
+				write_version, txn_signature, updated_at, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table),
+			account.Pubkey,
+			account.Slot,
+			account.Owner,
+			account.Lamports,
+			account.Data,
+			account.Executable,
+			account.RentEpoch,
+			account.WriteVersion,
+			account.TxnSignature,
+			account.UpdatedAt,
+			account.CreatedAt,
+		)
+	case err != nil:
+		return fmt.Errorf("error locking %s row: %v", table, err)
+	case account.Slot < slot || (account.Slot == slot && account.WriteVersion <= writeVersion):
+		return nil
+	default:
+		_, err = tx.Exec(fmt.Sprintf(`
+			UPDATE %s
+			SET slot = ?, owner = ?, lamports = ?, data = ?, executable = ?, rent_epoch = ?,
+				write_version = ?, txn_signature = ?, updated_at = ?
+			WHERE pubkey = ?`, table),
+			account.Slot,
+			account.Owner,
+			account.Lamports,
+			account.Data,
+			account.Executable,
+			account.RentEpoch,
+			account.WriteVersion,
+			account.TxnSignature,
+			account.UpdatedAt,
+			account.Pubkey,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("error updating %s: %v", table, err)
+	}
+	return nil
+}
+
+// SaveAccountSnapshot stores a startup account update in the snapshot taken
+// at snapshotSlot, keeping the highest write_version seen for each account
+func SaveAccountSnapshot(db *sql.DB, snapshotSlot uint64, account *AccountUpdate) error {
+	_, err := db.Exec(`
+		INSERT INTO account_snapshots (
+			snapshot_slot, pubkey, owner, lamports, data, executable,
+			rent_epoch, write_version, updated_at, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			owner = IF(VALUES(write_version) > write_version, VALUES(owner), owner),
+			lamports = IF(VALUES(write_version) > write_version, VALUES(lamports), lamports),
+			data = IF(VALUES(write_version) > write_version, VALUES(data), data),
+			executable = IF(VALUES(write_version) > write_version, VALUES(executable), executable),
+			rent_epoch = IF(VALUES(write_version) > write_version, VALUES(rent_epoch), rent_epoch),
+			updated_at = IF(VALUES(write_version) > write_version, VALUES(updated_at), updated_at),
+			write_version = GREATEST(write_version, VALUES(write_version))`,
+		snapshotSlot,
+		account.Pubkey,
+		account.Owner,
+		account.Lamports,
+		account.Data,
+		account.Executable,
+		account.RentEpoch,
+		account.WriteVersion,
+		account.UpdatedAt,
+		account.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving snapshot account %s: %v", account.Pubkey, err)
+	}
+	return nil
+}
+
+// snapshotSeedBatch is the number of snapshot rows copied per transaction
+const snapshotSeedBatch = 1000
+
+// SeedLatestFromSnapshot copies the snapshot taken at snapshotSlot into the
+// latest account state. Accounts that already have a newer state are left
+// untouched. It returns the number of snapshot rows processed.
+func SeedLatestFromSnapshot(db *sql.DB, snapshotSlot uint64) (int, error) {
+	total := 0
+	after := ""
+	for {
+		batch, err := loadSnapshotBatch(db, snapshotSlot, after)
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return total, fmt.Errorf("error starting transaction: %v", err)
+		}
+		for _, account := range batch {
+			if err := upsertLatestAccount(tx, "accounts_latest", account); err != nil {
+				tx.Rollback()
+				return total, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return total, fmt.Errorf("error committing transaction: %v", err)
+		}
+
+		total += len(batch)
+		after = batch[len(batch)-1].Pubkey
+	}
+}
+
+// loadSnapshotBatch reads the next batch of snapshot rows ordered by pubkey
+func loadSnapshotBatch(db *sql.DB, snapshotSlot uint64, after string) ([]*AccountUpdate, error) {
+	rows, err := db.Query(`
+		SELECT pubkey, owner, lamports, data, executable, rent_epoch, write_version, created_at
+		FROM account_snapshots
+		WHERE snapshot_slot = ? AND pubkey > ?
+		ORDER BY pubkey
+		LIMIT ?`, snapshotSlot, after, snapshotSeedBatch)
+	if err != nil {
+		return nil, fmt.Errorf("error querying snapshot %d: %v", snapshotSlot, err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var batch []*AccountUpdate
+	for rows.Next() {
+		account := &AccountUpdate{Slot: snapshotSlot, UpdatedAt: now}
+		if err := rows.Scan(&account.Pubkey, &account.Owner, &account.Lamports, &account.Data,
+			&account.Executable, &account.RentEpoch, &account.WriteVersion, &account.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning snapshot account: %v", err)
+		}
+		batch = append(batch, account)
+	}
+	return batch, rows.Err()
+}
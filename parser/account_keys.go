@@ -0,0 +1,135 @@
+package parser
+
+import (
+	pb "goblockstore/proto"
+	"time"
+
+	"github.com/mr-tron/base58"
+)
+
+// Account sources
+const (
+	AccountSourceStatic         = "static"
+	AccountSourceLoadedWritable = "loaded_writable"
+	AccountSourceLoadedReadonly = "loaded_readonly"
+)
+
+// Transaction versions
+const (
+	TransactionVersionLegacy = "legacy"
+	TransactionVersion0      = "0"
+)
+
+// accountKey is one entry of a transaction's full account list
+type accountKey struct {
+	Pubkey     string
+	IsSigner   bool
+	IsWritable bool
+	Source     string
+	// LookupTable is the address lookup table a loaded account came from
+	LookupTable *string
+}
+
+// accountKeys builds the full account list of a transaction: the static keys,
+// then the addresses loaded writable, then those loaded readonly. Instruction
+// and balance indexes refer to positions in this list.
+func accountKeys(message *pb.Message, meta *pb.TransactionStatusMeta) []accountKey {
+	if message == nil {
+		return nil
+	}
+	header := message.GetHeader()
+	numSigners := int(header.GetNumRequiredSignatures())
+	numWritableSigners := numSigners - int(header.GetNumReadonlySignedAccounts())
+	numWritableUnsigned := len(message.AccountKeys) - int(header.GetNumReadonlyUnsignedAccounts())
+
+	keys := make([]accountKey, 0, len(message.AccountKeys)+len(meta.GetLoadedWritableAddresses())+len(meta.GetLoadedReadonlyAddresses()))
+	for j, key := range message.AccountKeys {
+		keys = append(keys, accountKey{
+			Pubkey:     base58.Encode(key),
+			IsSigner:   j < numSigners,
+			IsWritable: j < numWritableSigners || (j >= numSigners && j < numWritableUnsigned),
+			Source:     AccountSourceStatic,
+		})
+	}
+
+	// Loaded addresses follow the lookups in order, writable indexes of every
+	// table first and readonly indexes after
+	var writableTables, readonlyTables []*string
+	for _, lookup := range message.AddressTableLookups {
+		table := base58.Encode(lookup.AccountKey)
+		for range lookup.WritableIndexes {
+			writableTables = append(writableTables, &table)
+		}
+		for range lookup.ReadonlyIndexes {
+			readonlyTables = append(readonlyTables, &table)
+		}
+	}
+	for j, key := range meta.GetLoadedWritableAddresses() {
+		account := accountKey{Pubkey: base58.Encode(key), IsWritable: true, Source: AccountSourceLoadedWritable}
+		if j < len(writableTables) {
+			account.LookupTable = writableTables[j]
+		}
+		keys = append(keys, account)
+	}
+	for j, key := range meta.GetLoadedReadonlyAddresses() {
+		account := accountKey{Pubkey: base58.Encode(key), Source: AccountSourceLoadedReadonly}
+		if j < len(readonlyTables) {
+			account.LookupTable = readonlyTables[j]
+		}
+		keys = append(keys, account)
+	}
+	return keys
+}
+
+// transactionVersion returns the version of a transaction message
+func transactionVersion(message *pb.Message) string {
+	if message.GetVersioned() {
+		return TransactionVersion0
+	}
+	return TransactionVersionLegacy
+}
+
+// programId resolves a program id index against the full account list
+func programId(keys []accountKey, index uint32) string {
+	if int(index) < len(keys) {
+		return keys[index].Pubkey
+	}
+	return ""
+}
+
+// resolveAccounts maps an instruction's account indexes to pubkeys. Indexes
+// beyond the account list resolve to an empty string.
+func resolveAccounts(keys []accountKey, indexes []byte) []string {
+	if len(indexes) == 0 {
+		return nil
+	}
+	accounts := make([]string, len(indexes))
+	for j, index := range indexes {
+		if int(index) < len(keys) {
+			accounts[j] = keys[index].Pubkey
+		}
+	}
+	return accounts
+}
+
+// appendInstructionAccounts adds a row per account of the instruction at path
+func appendInstructionAccounts(result *ParsedBlock, txIndex int, path, programId string, keys []accountKey, indexes []byte, now time.Time) {
+	for position, index := range indexes {
+		account := InstructionAccount{
+			Slot:             result.Block.Slot,
+			TransactionIndex: txIndex,
+			InstructionPath:  path,
+			Position:         position,
+			ProgramId:        programId,
+			CreatedAt:        now,
+			UpdatedAt:        now,
+		}
+		if int(index) < len(keys) {
+			key := keys[index]
+			account.Pubkey = key.Pubkey
+			account.IsSigner = key.IsSigner
+			account.IsWritable = key.IsWritable
+		}
+		result.InstructionAccounts = append(result.InstructionAccounts, account)
+	}
+}
@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"testing"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+// versionedTransaction returns a v0 transaction at index with four static
+// keys and addresses loaded from two lookup tables:
+//
+//	0 signer writable, 1 signer readonly, 2 writable, 3 readonly (program),
+//	4-5 loaded writable from tables 0xa0 and 0xb0,
+//	6-8 loaded readonly from tables 0xa0, 0xa0 and 0xb0
+func versionedTransaction(index uint64) *pb.SubscribeUpdateTransactionInfo {
+	return &pb.SubscribeUpdateTransactionInfo{
+		Signature: testKey(byte(index)),
+		Index:     index,
+		Transaction: &pb.Transaction{
+			Signatures: [][]byte{testKey(byte(index)), testKey(byte(index) + 1)},
+			Message: &pb.Message{
+				Header: &pb.MessageHeader{
+					NumRequiredSignatures:       2,
+					NumReadonlySignedAccounts:   1,
+					NumReadonlyUnsignedAccounts: 1,
+				},
+				AccountKeys: [][]byte{testKey(1), testKey(2), testKey(3), testKey(4)},
+				Versioned:   true,
+				AddressTableLookups: []*pb.MessageAddressTableLookup{
+					{AccountKey: testKey(0xa0), WritableIndexes: []byte{0}, ReadonlyIndexes: []byte{1, 2}},
+					{AccountKey: testKey(0xb0), WritableIndexes: []byte{5}, ReadonlyIndexes: []byte{6}},
+				},
+			},
+		},
+		Meta: &pb.TransactionStatusMeta{
+			LoadedWritableAddresses: [][]byte{testKey(0x10), testKey(0x11)},
+			LoadedReadonlyAddresses: [][]byte{testKey(0x20), testKey(0x21), testKey(0x22)},
+		},
+	}
+}
+
+func TestAccountKeys(t *testing.T) {
+	tx := versionedTransaction(0)
+	keys := accountKeys(tx.Transaction.Message, tx.Meta)
+
+	tests := []struct {
+		key      byte
+		signer   bool
+		writable bool
+		source   string
+		table    byte // 0 for static keys
+	}{
+		{1, true, true, AccountSourceStatic, 0},
+		{2, true, false, AccountSourceStatic, 0},
+		{3, false, true, AccountSourceStatic, 0},
+		{4, false, false, AccountSourceStatic, 0},
+		{0x10, false, true, AccountSourceLoadedWritable, 0xa0},
+		{0x11, false, true, AccountSourceLoadedWritable, 0xb0},
+		{0x20, false, false, AccountSourceLoadedReadonly, 0xa0},
+		{0x21, false, false, AccountSourceLoadedReadonly, 0xa0},
+		{0x22, false, false, AccountSourceLoadedReadonly, 0xb0},
+	}
+	if len(keys) != len(tests) {
+		t.Fatalf("%d keys, want %d", len(keys), len(tests))
+	}
+	for j, tt := range tests {
+		key := keys[j]
+		if key.Pubkey != base58.Encode(testKey(tt.key)) {
+			t.Errorf("key %d: pubkey %s, want key %#x", j, key.Pubkey, tt.key)
+		}
+		if key.IsSigner != tt.signer || key.IsWritable != tt.writable {
+			t.Errorf("key %d: signer %v writable %v, want %v %v", j, key.IsSigner, key.IsWritable, tt.signer, tt.writable)
+		}
+		if key.Source != tt.source {
+			t.Errorf("key %d: source %s, want %s", j, key.Source, tt.source)
+		}
+		switch {
+		case tt.table == 0 && key.LookupTable != nil:
+			t.Errorf("key %d: lookup table %s, want none", j, *key.LookupTable)
+		case tt.table != 0 && (key.LookupTable == nil || *key.LookupTable != base58.Encode(testKey(tt.table))):
+			t.Errorf("key %d: lookup table %v, want %#x", j, key.LookupTable, tt.table)
+		}
+	}
+}
+
+func TestAccountKeysLegacy(t *testing.T) {
+	tx := testTransaction(0)
+	keys := accountKeys(tx.Transaction.Message, tx.Meta)
+	if len(keys) != 2 {
+		t.Fatalf("%d keys, want 2", len(keys))
+	}
+	if !keys[0].IsSigner || !keys[0].IsWritable || keys[1].IsSigner || !keys[1].IsWritable {
+		t.Errorf("keys %+v", keys)
+	}
+	if got := transactionVersion(tx.Transaction.Message); got != TransactionVersionLegacy {
+		t.Errorf("version %s, want %s", got, TransactionVersionLegacy)
+	}
+}
+
+func TestParseVersionedTransactionAccounts(t *testing.T) {
+	tx := versionedTransaction(4)
+	// Invoke a program loaded from a lookup table
+	tx.Transaction.Message.Instructions = []*pb.CompiledInstruction{{ProgramIdIndex: 6, Accounts: []byte{0, 4}}}
+	parsed, err := ParseTransaction(&pb.SubscribeUpdateTransaction{Slot: 100, Transaction: tx})
+	if err != nil {
+		t.Fatalf("ParseTransaction: %v", err)
+	}
+
+	if got := parsed.Transactions[0].Version; got != TransactionVersion0 {
+		t.Errorf("version %s, want %s", got, TransactionVersion0)
+	}
+	if got, want := parsed.TransactionInstructions[0].ProgramId, base58.Encode(testKey(0x20)); got != want {
+		t.Errorf("program id %s, want %s", got, want)
+	}
+	if len(parsed.TransactionAccounts) != 9 {
+		t.Fatalf("%d accounts, want 9", len(parsed.TransactionAccounts))
+	}
+	account := parsed.TransactionAccounts[5]
+	if account.AccountIndex != 5 || account.Source != AccountSourceLoadedWritable || account.LookupTable == nil {
+		t.Errorf("account 5: %+v", account)
+	}
+}
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LastSavedSlot returns the highest slot committed to the blocks table.
+// The boolean is false when no block has been saved yet.
+func LastSavedSlot(db *sql.DB) (uint64, bool, error) {
+	var slot sql.NullInt64
+	err := db.QueryRow(`SELECT MAX(slot) FROM blocks WHERE deleted_at IS NULL`).Scan(&slot)
+	if err != nil {
+		return 0, false, fmt.Errorf("error querying last saved slot: %v", err)
+	}
+	if !slot.Valid {
+		return 0, false, nil
+	}
+	return uint64(slot.Int64), true, nil
+}
+
+// LoadCheckpoint returns the stored checkpoint for a pipeline, or nil if the
+// pipeline has not committed any block yet
+func LoadCheckpoint(db *sql.DB, pipeline string) (*Checkpoint, error) {
+	cp := Checkpoint{Pipeline: pipeline}
+	err := db.QueryRow(`
+		SELECT last_slot, blockhash, commitment, updated_at
+		FROM ingest_checkpoints
+		WHERE pipeline = ?`, pipeline).Scan(&cp.LastSlot, &cp.Blockhash, &cp.Commitment, &cp.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading checkpoint for pipeline %s: %v", pipeline, err)
+	}
+	return &cp, nil
+}
+
+// ResumeSlot returns the slot a pipeline has committed up to. It prefers the
+// pipeline checkpoint and falls back to the blocks table for deployments that
+// predate checkpoints.
+func ResumeSlot(db *sql.DB, pipeline string) (uint64, bool, error) {
+	cp, err := LoadCheckpoint(db, pipeline)
+	if err != nil {
+		return 0, false, err
+	}
+	if cp != nil && cp.LastSlot > 0 {
+		return cp.LastSlot, true, nil
+	}
+	return LastSavedSlot(db)
+}
+
+// lockCheckpoint takes a row lock on the pipeline checkpoint, creating it if needed
+func lockCheckpoint(tx *sql.Tx, pipeline string) error {
+	_, err := tx.Exec(`
+		INSERT IGNORE INTO ingest_checkpoints (pipeline, last_slot, blockhash, commitment, updated_at)
+		VALUES (?, 0, '', '', ?)`, pipeline, time.Now())
+	if err != nil {
+		return fmt.Errorf("error creating checkpoint for pipeline %s: %v", pipeline, err)
+	}
+
+	var lastSlot uint64
+	err = tx.QueryRow(`SELECT last_slot FROM ingest_checkpoints WHERE pipeline = ? FOR UPDATE`, pipeline).Scan(&lastSlot)
+	if err != nil {
+		return fmt.Errorf("error locking checkpoint for pipeline %s: %v", pipeline, err)
+	}
+	return nil
+}
+
+// advanceCheckpoint moves the pipeline checkpoint forward to cp. It never
+// moves a checkpoint backwards, so replaying an older block is harmless.
+func advanceCheckpoint(tx *sql.Tx, cp *Checkpoint) error {
+	cp.UpdatedAt = time.Now()
+	_, err := tx.Exec(`
+		UPDATE ingest_checkpoints
+		SET blockhash = ?, commitment = ?, last_slot = ?, updated_at = ?
+		WHERE pipeline = ? AND last_slot < ?`,
+		cp.Blockhash, cp.Commitment, cp.LastSlot, cp.UpdatedAt, cp.Pipeline, cp.LastSlot)
+	if err != nil {
+		return fmt.Errorf("error advancing checkpoint for pipeline %s: %v", cp.Pipeline, err)
+	}
+	return nil
+}
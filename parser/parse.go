@@ -3,6 +3,8 @@ package parser
 import (
 	"fmt"
 	pb "goblockstore/proto"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/mr-tron/base58"
@@ -23,217 +25,440 @@ func ParseBlock(block *pb.SubscribeUpdateBlock) (*ParsedBlock, error) {
 			BlockTime:         blockTime,
 			CreatedAt:         now,
 			UpdatedAt:         now,
+
+			ExecutedTransactionCount: block.ExecutedTransactionCount,
+			EntriesCount:             block.EntriesCount,
 		},
 	}
 
 	// Parse rewards
-	// if rewards := block.GetRewards().GetRewards(); rewards != nil {
-	// 	for i, reward := range rewards {
-	// 		blockReward := BlockReward{
-	// 			Slot:        result.Block.Slot,
-	// 			RewardIndex: i,
-	// 			Lamports:    reward.Lamports,
-	// 			PostBalance: reward.PostBalance,
-	// 			Pubkey:      reward.Pubkey,
-	// 			RewardType:  reward.RewardType.String(),
-	// 			CreatedAt:   now,
-	// 			UpdatedAt:   now,
-	// 		}
-	// 		if reward.Commission != "" {
-	// 			var commission int64
-	// 			fmt.Sscanf(reward.Commission, "%d", &commission)
-	// 			blockReward.Commission = &commission
-	// 		}
-	// 		result.BlockRewards = append(result.BlockRewards, blockReward)
-	// 	}
-	// }
+	rewards, err := parseRewards(block.GetRewards().GetRewards(), result.Block.Slot, now)
+	if err != nil {
+		return nil, err
+	}
+	result.BlockRewards = rewards
+	result.Block.NumPartitions = numPartitions(block.GetRewards())
+
+	// Parse entries
+	result.BlockEntries = parseEntries(block.GetEntries(), result.Block.Slot, now)
 
 	// Parse transactions
 	if txs := block.GetTransactions(); txs != nil {
-		for i, tx := range txs {
+		for _, tx := range txs {
 			if tx.IsVote {
 				continue
 			}
-			transaction := Transaction{
+			if err := parseTransaction(result, tx, blockTime, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result.Block.TransactionCount = len(result.Transactions)
+	result.Block.Successful = true
+
+	return result, nil
+}
+
+// ParseTransaction parses a transaction streamed ahead of its block with the
+// same logic ParseBlock uses. The block hash and time are unknown until the
+// block arrives, so only the transaction rows of the result are filled in.
+func ParseTransaction(update *pb.SubscribeUpdateTransaction) (*ParsedBlock, error) {
+	info := update.GetTransaction()
+	if info == nil || info.Meta == nil {
+		return nil, fmt.Errorf("transaction update at slot %d has no transaction status", update.Slot)
+	}
+
+	result := &ParsedBlock{
+		Block: Block{Slot: update.Slot},
+	}
+	if err := parseTransaction(result, info, time.Time{}, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parseTransaction parses a single transaction and appends it and its child
+// rows to result. Rows are keyed by the transaction's index in its block,
+// which differs from its position in filtered or partial blocks.
+func parseTransaction(result *ParsedBlock, tx *pb.SubscribeUpdateTransactionInfo, blockTime, now time.Time) error {
+	i := int(tx.Index)
+	transaction := Transaction{
+		Slot:             result.Block.Slot,
+		TransactionIndex: i,
+		BlockTime:        blockTime,
+		BlockHash:        result.Block.Blockhash,
+		Fee:              tx.Meta.Fee,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		Successful:       tx.Meta.Err == nil,
+		EntryIndex:       entryIndexOf(result.BlockEntries, uint64(i)),
+	}
+
+	if tx.Meta != nil {
+		if tx.Meta.Err != nil {
+			errStr := string(tx.Meta.Err.Err)
+			transaction.Err = &TransactionError{Data: errStr}
+		}
+		if tx.Meta.ComputeUnitsConsumed != nil {
+			transaction.ComputeUnitsConsumed = *tx.Meta.ComputeUnitsConsumed
+		}
+	}
+
+	if tx.Transaction != nil && tx.Transaction.Message != nil {
+		keys := accountKeys(tx.Transaction.Message, tx.Meta)
+
+		if header := tx.Transaction.Message.Header; header != nil {
+			transaction.NumRequiredSignatures = header.NumRequiredSignatures
+			transaction.NumReadonlySignedAccounts = header.NumReadonlySignedAccounts
+			transaction.NumReadonlyUnsignedAccounts = header.NumReadonlyUnsignedAccounts
+		}
+		transaction.Version = transactionVersion(tx.Transaction.Message)
+		if tx.Transaction.Message.RecentBlockhash != nil {
+			transaction.RecentBlockhash = base58.Encode(tx.Transaction.Message.RecentBlockhash)
+		}
+
+		// Parse instructions
+		for j, inst := range tx.Transaction.Message.Instructions {
+			instruction := Instruction{
 				Slot:             result.Block.Slot,
 				TransactionIndex: i,
-				BlockTime:        blockTime,
-				BlockHash:        result.Block.Blockhash,
-				Fee:              tx.Meta.Fee,
+				InstructionIndex: j,
+				ProgramIdIndex:   int(inst.ProgramIdIndex),
+				Data:             inst.Data,
 				CreatedAt:        now,
 				UpdatedAt:        now,
-				Successful:       tx.Meta.Err == nil,
 			}
+			instruction.ProgramId = programId(keys, inst.ProgramIdIndex)
+			instruction.Accounts = resolveAccounts(keys, inst.Accounts)
+			result.TransactionInstructions = append(result.TransactionInstructions, instruction)
+			appendInstructionAccounts(result, i, strconv.Itoa(j), instruction.ProgramId, keys, inst.Accounts, now)
+		}
 
-			if tx.Meta != nil {
-				if tx.Meta.Err != nil {
-					errStr := string(tx.Meta.Err.Err)
-					transaction.Err = &TransactionError{Data: errStr}
+		// Parse inner instructions
+		for _, inner := range tx.Meta.GetInnerInstructions() {
+			for j, inst := range inner.Instructions {
+				instruction := TransactionInnerInstruction{
+					Slot:                  result.Block.Slot,
+					SlotIndex:             i,
+					InstructionIndex:      int(inner.Index),
+					InnerInstructionIndex: j,
+					ProgramId:             programId(keys, inst.ProgramIdIndex),
+					ProgramIdIndex:        int(inst.ProgramIdIndex),
+					Data:                  inst.Data,
+					Accounts:              resolveAccounts(keys, inst.Accounts),
+					CreatedAt:             now,
+					UpdatedAt:             now,
 				}
-				if tx.Meta.ComputeUnitsConsumed != nil {
-					transaction.ComputeUnitsConsumed = *tx.Meta.ComputeUnitsConsumed
+				if inst.StackHeight != nil {
+					height := int64(*inst.StackHeight)
+					instruction.StackHeight = &height
 				}
+				result.TransactionInnerInstructions = append(result.TransactionInnerInstructions, instruction)
+				path := fmt.Sprintf("%d.%d", inner.Index, j)
+				appendInstructionAccounts(result, i, path, instruction.ProgramId, keys, inst.Accounts, now)
 			}
+		}
 
-			if tx.Transaction != nil && tx.Transaction.Message != nil {
-				if header := tx.Transaction.Message.Header; header != nil {
-					transaction.NumRequiredSignatures = header.NumRequiredSignatures
-					transaction.NumReadonlySignedAccounts = header.NumReadonlySignedAccounts
-					transaction.NumReadonlyUnsignedAccounts = header.NumReadonlyUnsignedAccounts
-				}
-				if tx.Transaction.Message.RecentBlockhash != nil {
-					transaction.RecentBlockhash = base58.Encode(tx.Transaction.Message.RecentBlockhash)
+		// Parse accounts
+		if tx.Meta != nil {
+			for j, key := range keys {
+				account := TransactionAccount{
+					Slot:             result.Block.Slot,
+					TransactionIndex: i,
+					AccountIndex:     j,
+					Pubkey:           key.Pubkey,
+					AccountAddress:   key.Pubkey,
+					IsSigner:         key.IsSigner,
+					IsWritable:       key.IsWritable,
+					Source:           key.Source,
+					LookupTable:      key.LookupTable,
+					CreatedAt:        now,
+					UpdatedAt:        now,
 				}
 
-				// Parse instructions
-				for j, inst := range tx.Transaction.Message.Instructions {
-					instruction := Instruction{
-						Slot:             result.Block.Slot,
-						TransactionIndex: i,
-						InstructionIndex: j,
-						ProgramIdIndex:   int(inst.ProgramIdIndex),
-						Data:             inst.Data,
-						CreatedAt:        now,
-						UpdatedAt:        now,
-					}
-					if tx.Transaction.Message.AccountKeys != nil && int(inst.ProgramIdIndex) < len(tx.Transaction.Message.AccountKeys) {
-						instruction.ProgramId = base58.Encode(tx.Transaction.Message.AccountKeys[inst.ProgramIdIndex])
-					}
-					result.TransactionInstructions = append(result.TransactionInstructions, instruction)
+				if j < len(tx.Meta.PreBalances) {
+					account.PreBalance = tx.Meta.PreBalances[j]
 				}
-
-				// Parse accounts
-				if tx.Meta != nil && tx.Transaction.Message.AccountKeys != nil {
-					for j, key := range tx.Transaction.Message.AccountKeys {
-						account := TransactionAccount{
-							Slot:             result.Block.Slot,
-							TransactionIndex: i,
-							AccountIndex:     j,
-							Pubkey:           base58.Encode(key),
-							AccountAddress:   base58.Encode(key),
-							IsSigner:         j < int(tx.Transaction.Message.Header.NumRequiredSignatures),
-							IsWritable: j < int(tx.Transaction.Message.Header.NumRequiredSignatures-tx.Transaction.Message.Header.NumReadonlySignedAccounts) ||
-								(j >= int(tx.Transaction.Message.Header.NumRequiredSignatures) && j < len(tx.Transaction.Message.AccountKeys)-int(tx.Transaction.Message.Header.NumReadonlyUnsignedAccounts)),
-							CreatedAt: now,
-							UpdatedAt: now,
-						}
-
-						if j < len(tx.Meta.PreBalances) {
-							account.PreBalance = tx.Meta.PreBalances[j]
-						}
-						if j < len(tx.Meta.PostBalances) {
-							account.PostBalance = tx.Meta.PostBalances[j]
-							if account.PreBalance > 0 {
-								account.BalanceChange = int64(account.PostBalance) - int64(account.PreBalance)
-							}
-						}
-
-						result.TransactionAccounts = append(result.TransactionAccounts, account)
+				if j < len(tx.Meta.PostBalances) {
+					account.PostBalance = tx.Meta.PostBalances[j]
+					if account.PreBalance > 0 {
+						account.BalanceChange = int64(account.PostBalance) - int64(account.PreBalance)
 					}
 				}
 
-				// Parse token balances
-				if tx.Meta != nil && tx.Meta.PreTokenBalances != nil {
-					preBalMap := make(map[int]*pb.TokenBalance)
-					for _, bal := range tx.Meta.PreTokenBalances {
-						preBalMap[int(bal.AccountIndex)] = bal
-					}
+				result.TransactionAccounts = append(result.TransactionAccounts, account)
+			}
+		}
 
-					postBalMap := make(map[int]*pb.TokenBalance)
-					for _, bal := range tx.Meta.PostTokenBalances {
-						postBalMap[int(bal.AccountIndex)] = bal
-					}
+		// Parse token balances
+		if tx.Meta != nil && tx.Meta.PreTokenBalances != nil {
+			preBalMap := make(map[int]*pb.TokenBalance)
+			for _, bal := range tx.Meta.PreTokenBalances {
+				preBalMap[int(bal.AccountIndex)] = bal
+			}
 
-					// Combine pre and post balances
-					for idx, preBal := range preBalMap {
-						tokenBal := TransactionTokenBalance{
-							Slot:             result.Block.Slot,
-							TransactionIndex: i,
-							AccountIndex:     idx,
-							Mint:             preBal.Mint,
-							Owner:            preBal.Owner,
-							Decimals:         int(preBal.UiTokenAmount.Decimals),
-							PreAmount:        preBal.UiTokenAmount.Amount,
-							PreUiAmount:      preBal.UiTokenAmount.UiAmount,
-							CreatedAt:        now,
-							UpdatedAt:        now,
-						}
-
-						if postBal, exists := postBalMap[idx]; exists {
-							tokenBal.Amount = postBal.UiTokenAmount.Amount
-							tokenBal.UiTokenAmount = postBal.UiTokenAmount.UiAmount
-							tokenBal.PostAmount = postBal.UiTokenAmount.Amount
-							tokenBal.PostUiAmount = postBal.UiTokenAmount.UiAmount
-						}
-
-						result.TransactionTokenBalances = append(result.TransactionTokenBalances, tokenBal)
-					}
+			postBalMap := make(map[int]*pb.TokenBalance)
+			for _, bal := range tx.Meta.PostTokenBalances {
+				postBalMap[int(bal.AccountIndex)] = bal
+			}
 
-					// Add any post balances that didn't have pre balances
-					for idx, postBal := range postBalMap {
-						if _, exists := preBalMap[idx]; !exists {
-							tokenBal := TransactionTokenBalance{
-								Slot:             result.Block.Slot,
-								TransactionIndex: i,
-								AccountIndex:     idx,
-								Mint:             postBal.Mint,
-								Owner:            postBal.Owner,
-								Amount:           postBal.UiTokenAmount.Amount,
-								UiTokenAmount:    postBal.UiTokenAmount.UiAmount,
-								Decimals:         int(postBal.UiTokenAmount.Decimals),
-								PostAmount:       postBal.UiTokenAmount.Amount,
-								PostUiAmount:     postBal.UiTokenAmount.UiAmount,
-								CreatedAt:        now,
-								UpdatedAt:        now,
-							}
-							result.TransactionTokenBalances = append(result.TransactionTokenBalances, tokenBal)
-						}
-					}
+			// Combine pre and post balances
+			for idx, preBal := range preBalMap {
+				tokenBal := TransactionTokenBalance{
+					Slot:             result.Block.Slot,
+					TransactionIndex: i,
+					AccountIndex:     idx,
+					Mint:             preBal.Mint,
+					Owner:            preBal.Owner,
+					Decimals:         int(preBal.UiTokenAmount.Decimals),
+					PreAmount:        preBal.UiTokenAmount.Amount,
+					PreUiAmount:      preBal.UiTokenAmount.UiAmount,
+					CreatedAt:        now,
+					UpdatedAt:        now,
 				}
-			}
 
-			// Parse signatures
-			if tx.Transaction != nil {
-				for _, sig := range tx.Transaction.Signatures {
-					result.TransactionSignatures = append(result.TransactionSignatures, TransactionSignature{
-						Signature:        base58.Encode(sig),
-						Slot:             result.Block.Slot,
-						TransactionIndex: i,
-						CreatedAt:        now,
-						UpdatedAt:        now,
-					})
+				if postBal, exists := postBalMap[idx]; exists {
+					tokenBal.Amount = postBal.UiTokenAmount.Amount
+					tokenBal.UiTokenAmount = postBal.UiTokenAmount.UiAmount
+					tokenBal.PostAmount = postBal.UiTokenAmount.Amount
+					tokenBal.PostUiAmount = postBal.UiTokenAmount.UiAmount
 				}
+
+				result.TransactionTokenBalances = append(result.TransactionTokenBalances, tokenBal)
 			}
 
-			// Parse logs
-			if tx.Meta != nil && tx.Meta.LogMessages != nil {
-				for j, log := range tx.Meta.LogMessages {
-					txLog := TransactionLog{
+			// Add any post balances that didn't have pre balances
+			for idx, postBal := range postBalMap {
+				if _, exists := preBalMap[idx]; !exists {
+					tokenBal := TransactionTokenBalance{
 						Slot:             result.Block.Slot,
 						TransactionIndex: i,
-						LogIndex:         j,
-						Log:              log,
+						AccountIndex:     idx,
+						Mint:             postBal.Mint,
+						Owner:            postBal.Owner,
+						Amount:           postBal.UiTokenAmount.Amount,
+						UiTokenAmount:    postBal.UiTokenAmount.UiAmount,
+						Decimals:         int(postBal.UiTokenAmount.Decimals),
+						PostAmount:       postBal.UiTokenAmount.Amount,
+						PostUiAmount:     postBal.UiTokenAmount.UiAmount,
 						CreatedAt:        now,
 						UpdatedAt:        now,
 					}
-					// Extract program ID from log if possible
-					if len(result.TransactionInstructions) > 0 {
-						txLog.ProgramId = result.TransactionInstructions[0].ProgramId
-					}
-					result.TransactionLogs = append(result.TransactionLogs, txLog)
+					result.TransactionTokenBalances = append(result.TransactionTokenBalances, tokenBal)
 				}
 			}
+		}
+	}
 
-			result.Transactions = append(result.Transactions, transaction)
+	// Parse signatures
+	if tx.Transaction != nil {
+		for _, sig := range tx.Transaction.Signatures {
+			result.TransactionSignatures = append(result.TransactionSignatures, TransactionSignature{
+				Signature:        base58.Encode(sig),
+				Slot:             result.Block.Slot,
+				TransactionIndex: i,
+				CreatedAt:        now,
+				UpdatedAt:        now,
+			})
 		}
 	}
 
-	result.Block.TransactionCount = len(result.Transactions)
-	result.Block.Successful = true
+	// Parse logs
+	if tx.Meta != nil && tx.Meta.LogMessages != nil {
+		for j, log := range tx.Meta.LogMessages {
+			txLog := TransactionLog{
+				Slot:             result.Block.Slot,
+				TransactionIndex: i,
+				LogIndex:         j,
+				Log:              log,
+				CreatedAt:        now,
+				UpdatedAt:        now,
+			}
+			// Extract program ID from log if possible
+			if len(result.TransactionInstructions) > 0 {
+				txLog.ProgramId = result.TransactionInstructions[0].ProgramId
+			}
+			result.TransactionLogs = append(result.TransactionLogs, txLog)
+		}
+	}
+
+	// Parse rewards
+	rewards, err := parseRewards(tx.Meta.GetRewards(), result.Block.Slot, now)
+	if err != nil {
+		return fmt.Errorf("error parsing rewards of transaction %d: %v", i, err)
+	}
+	for _, reward := range rewards {
+		result.TransactionRewards = append(result.TransactionRewards, TransactionReward{
+			Slot:             reward.Slot,
+			TransactionIndex: i,
+			RewardIndex:      reward.RewardIndex,
+			Pubkey:           reward.Pubkey,
+			Lamports:         reward.Lamports,
+			PostBalance:      reward.PostBalance,
+			RewardType:       reward.RewardType,
+			Commission:       reward.Commission,
+			CreatedAt:        now,
+			UpdatedAt:        now,
+		})
+	}
+
+	result.Transactions = append(result.Transactions, transaction)
+	return nil
+}
+
+// ParseBlockMeta parses a Yellowstone block header into our structured format.
+// Only the block and its rewards are filled in; TransactionCount stays zero
+// since headers do not say how many of the executed transactions were votes.
+func ParseBlockMeta(meta *pb.SubscribeUpdateBlockMeta) (*ParsedBlock, error) {
+	now := time.Now()
+
+	result := &ParsedBlock{
+		Block: Block{
+			Slot:              meta.Slot,
+			Blockhash:         meta.Blockhash,
+			ParentSlot:        meta.ParentSlot,
+			PreviousBlockhash: meta.ParentBlockhash,
+			BlockHeight:       meta.BlockHeight.GetBlockHeight(),
+			BlockTime:         time.Unix(meta.BlockTime.GetTimestamp(), 0),
+			Successful:        true,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+
+			ExecutedTransactionCount: meta.ExecutedTransactionCount,
+			EntriesCount:             meta.EntriesCount,
+		},
+	}
 
+	rewards, err := parseRewards(meta.GetRewards().GetRewards(), meta.Slot, now)
+	if err != nil {
+		return nil, err
+	}
+	result.BlockRewards = rewards
+	result.Block.NumPartitions = numPartitions(meta.GetRewards())
+
+	return result, nil
+}
+
+// parseRewards converts the rewards paid out in a block or transaction
+func parseRewards(rewards []*pb.Reward, slot uint64, now time.Time) ([]BlockReward, error) {
+	var result []BlockReward
+	for i, reward := range rewards {
+		commission, err := parseCommission(reward.Commission)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commission %q for reward %d: %v", reward.Commission, i, err)
+		}
+		result = append(result, BlockReward{
+			Slot:        slot,
+			RewardIndex: i,
+			Lamports:    reward.Lamports,
+			PostBalance: reward.PostBalance,
+			Pubkey:      reward.Pubkey,
+			RewardType:  rewardType(reward.RewardType),
+			Commission:  commission,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+	}
 	return result, nil
 }
 
+// rewardType converts a reward type, treating values unknown to this build as unspecified
+func rewardType(t pb.RewardType) RewardType {
+	switch t {
+	case pb.RewardType_Fee:
+		return RewardTypeFee
+	case pb.RewardType_Rent:
+		return RewardTypeRent
+	case pb.RewardType_Staking:
+		return RewardTypeStaking
+	case pb.RewardType_Voting:
+		return RewardTypeVoting
+	default:
+		return RewardTypeUnspecified
+	}
+}
+
+// parseCommission parses a vote account commission percentage, which is
+// only set on voting and staking rewards
+func parseCommission(commission string) (*uint8, error) {
+	if commission == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseUint(commission, 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	result := uint8(value)
+	return &result, nil
+}
+
+// numPartitions returns the number of partitions of partitioned epoch
+// rewards, or nil when the rewards are not partitioned
+func numPartitions(rewards *pb.Rewards) *uint64 {
+	if rewards.GetNumPartitions() == nil {
+		return nil
+	}
+	partitions := rewards.GetNumPartitions().GetNumPartitions()
+	return &partitions
+}
+
+// parseEntries converts the PoH entries of a block. Plugins built for Solana
+// 1.17 leave StartingTransactionIndex at zero, so it is derived from the
+// executed transaction counts of the preceding entries when missing.
+func parseEntries(entries []*pb.SubscribeUpdateEntry, slot uint64, now time.Time) []BlockEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	sorted := make([]*pb.SubscribeUpdateEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Index < sorted[b].Index })
+
+	result := make([]BlockEntry, 0, len(sorted))
+	var next uint64
+	for _, entry := range sorted {
+		start := entry.StartingTransactionIndex
+		if start == 0 {
+			start = next
+		}
+		result = append(result, BlockEntry{
+			Slot:                     slot,
+			EntryIndex:               entry.Index,
+			NumHashes:                entry.NumHashes,
+			Hash:                     base58.Encode(entry.Hash),
+			ExecutedTransactionCount: entry.ExecutedTransactionCount,
+			StartingTransactionIndex: start,
+			CreatedAt:                now,
+			UpdatedAt:                now,
+		})
+		next = start + entry.ExecutedTransactionCount
+	}
+	return result
+}
+
+// entryIndexOf returns the index of the entry that contains the transaction
+// at txIndex, or nil if the block carried no entries for it
+func entryIndexOf(entries []BlockEntry, txIndex uint64) *int64 {
+	// Entries are ordered by starting transaction index; find the last one starting at or before txIndex
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].StartingTransactionIndex > txIndex
+	}) - 1
+	for ; i >= 0; i-- {
+		entry := entries[i]
+		if entry.ExecutedTransactionCount == 0 {
+			continue
+		}
+		if txIndex < entry.StartingTransactionIndex+entry.ExecutedTransactionCount {
+			index := int64(entry.EntryIndex)
+			return &index
+		}
+		break
+	}
+	return nil
+}
+
 // parseUint64 parses a string into a uint64
 func parseUint64(s string) (uint64, error) {
 	var result uint64
@@ -0,0 +1,333 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+// testKey returns a 32-byte public key starting with b
+func testKey(b byte) []byte {
+	key := make([]byte, 32)
+	key[0] = b
+	return key
+}
+
+// testTransaction returns a minimal transaction at index in its block
+func testTransaction(index uint64) *pb.SubscribeUpdateTransactionInfo {
+	return &pb.SubscribeUpdateTransactionInfo{
+		Signature: testKey(byte(index)),
+		Index:     index,
+		Transaction: &pb.Transaction{
+			Signatures: [][]byte{testKey(byte(index))},
+			Message: &pb.Message{
+				Header:       &pb.MessageHeader{NumRequiredSignatures: 1},
+				AccountKeys:  [][]byte{testKey(1), testKey(2)},
+				Instructions: []*pb.CompiledInstruction{{ProgramIdIndex: 1, Accounts: []byte{0}}},
+			},
+		},
+		Meta: &pb.TransactionStatusMeta{LogMessages: []string{"Program log: hi"}},
+	}
+}
+
+func TestParseBlockFilteredTransactionIndexes(t *testing.T) {
+	// A filtered block carries only the transactions at block indexes 3 and 7
+	block := &pb.SubscribeUpdateBlock{
+		Slot: 100,
+		Entries: []*pb.SubscribeUpdateEntry{
+			{Slot: 100, Index: 0, ExecutedTransactionCount: 5, StartingTransactionIndex: 0},
+			{Slot: 100, Index: 1, ExecutedTransactionCount: 5, StartingTransactionIndex: 5},
+		},
+		Transactions: []*pb.SubscribeUpdateTransactionInfo{testTransaction(3), testTransaction(7)},
+	}
+
+	parsed, err := ParseBlock(block)
+	if err != nil {
+		t.Fatalf("ParseBlock: %v", err)
+	}
+
+	tests := []struct {
+		index int
+		entry int64
+	}{
+		{3, 0},
+		{7, 1},
+	}
+	for i, tt := range tests {
+		tx := parsed.Transactions[i]
+		if tx.TransactionIndex != tt.index {
+			t.Errorf("transaction %d: index %d, want %d", i, tx.TransactionIndex, tt.index)
+		}
+		if tx.EntryIndex == nil || *tx.EntryIndex != tt.entry {
+			t.Errorf("transaction %d: entry %v, want %d", i, tx.EntryIndex, tt.entry)
+		}
+		if got := parsed.TransactionInstructions[i].TransactionIndex; got != tt.index {
+			t.Errorf("instruction %d: transaction index %d, want %d", i, got, tt.index)
+		}
+		if got := parsed.TransactionSignatures[i].TransactionIndex; got != tt.index {
+			t.Errorf("signature %d: transaction index %d, want %d", i, got, tt.index)
+		}
+	}
+}
+
+func TestParseTransactionMatchesBlockKeys(t *testing.T) {
+	info := testTransaction(7)
+	block := &pb.SubscribeUpdateBlock{
+		Slot:         100,
+		Transactions: []*pb.SubscribeUpdateTransactionInfo{testTransaction(2), info},
+	}
+	fromBlock, err := ParseBlock(block)
+	if err != nil {
+		t.Fatalf("ParseBlock: %v", err)
+	}
+	streamed, err := ParseTransaction(&pb.SubscribeUpdateTransaction{Slot: 100, Transaction: info})
+	if err != nil {
+		t.Fatalf("ParseTransaction: %v", err)
+	}
+
+	type key struct {
+		slot  uint64
+		index int
+	}
+	blockKey := key{fromBlock.Transactions[1].Slot, fromBlock.Transactions[1].TransactionIndex}
+	streamedKey := key{streamed.Transactions[0].Slot, streamed.Transactions[0].TransactionIndex}
+	if blockKey != streamedKey {
+		t.Errorf("block key %v, streamed key %v", blockKey, streamedKey)
+	}
+	if got, want := streamed.TransactionLogs[0].TransactionIndex, fromBlock.TransactionLogs[1].TransactionIndex; got != want {
+		t.Errorf("streamed log index %d, block log index %d", got, want)
+	}
+	if !streamed.Transactions[0].BlockTime.IsZero() {
+		t.Errorf("streamed block time %v, want zero until reconciled", streamed.Transactions[0].BlockTime)
+	}
+}
+
+// withInnerInstructions gives a versioned transaction one outer instruction
+// invoking two inner instructions, the second one level deeper
+func withInnerInstructions(tx *pb.SubscribeUpdateTransactionInfo) *pb.SubscribeUpdateTransactionInfo {
+	two, three := uint32(2), uint32(3)
+	tx.Transaction.Message.Instructions = []*pb.CompiledInstruction{{ProgramIdIndex: 3, Accounts: []byte{0, 2}, Data: []byte{1}}}
+	tx.Meta.InnerInstructions = []*pb.InnerInstructions{{
+		Index: 0,
+		Instructions: []*pb.InnerInstruction{
+			{ProgramIdIndex: 6, Accounts: []byte{4, 1}, Data: []byte{2}, StackHeight: &two},
+			{ProgramIdIndex: 3, Accounts: []byte{8}, Data: []byte{3}, StackHeight: &three},
+		},
+	}}
+	return tx
+}
+
+func TestParseInnerInstructions(t *testing.T) {
+	tx := withInnerInstructions(versionedTransaction(5))
+	// Plugins predating stack heights leave them unset
+	legacy := withInnerInstructions(versionedTransaction(6))
+	legacy.Meta.InnerInstructions[0].Instructions[1].StackHeight = nil
+
+	parsed, err := ParseBlock(&pb.SubscribeUpdateBlock{Slot: 100, Transactions: []*pb.SubscribeUpdateTransactionInfo{tx, legacy}})
+	if err != nil {
+		t.Fatalf("ParseBlock: %v", err)
+	}
+
+	tests := []struct {
+		txIndex  int
+		inner    int
+		program  byte
+		height   int64 // 0 when unset
+		data     byte
+		accounts []byte
+	}{
+		{5, 0, 0x20, 2, 2, []byte{0x10, 2}},
+		{5, 1, 4, 3, 3, []byte{0x22}},
+		{6, 0, 0x20, 2, 2, []byte{0x10, 2}},
+		{6, 1, 4, 0, 3, []byte{0x22}},
+	}
+	if len(parsed.TransactionInnerInstructions) != len(tests) {
+		t.Fatalf("%d inner instructions, want %d", len(parsed.TransactionInnerInstructions), len(tests))
+	}
+	for i, tt := range tests {
+		inst := parsed.TransactionInnerInstructions[i]
+		if inst.SlotIndex != tt.txIndex || inst.InstructionIndex != 0 || inst.InnerInstructionIndex != tt.inner {
+			t.Errorf("inner instruction %d: at %d/%d/%d, want %d/0/%d", i, inst.SlotIndex, inst.InstructionIndex, inst.InnerInstructionIndex, tt.txIndex, tt.inner)
+		}
+		if inst.ProgramId != base58.Encode(testKey(tt.program)) {
+			t.Errorf("inner instruction %d: program %s, want key %#x", i, inst.ProgramId, tt.program)
+		}
+		switch {
+		case tt.height == 0 && inst.StackHeight != nil:
+			t.Errorf("inner instruction %d: stack height %d, want unset", i, *inst.StackHeight)
+		case tt.height != 0 && (inst.StackHeight == nil || *inst.StackHeight != tt.height):
+			t.Errorf("inner instruction %d: stack height %v, want %d", i, inst.StackHeight, tt.height)
+		}
+		if len(inst.Data) != 1 || inst.Data[0] != tt.data {
+			t.Errorf("inner instruction %d: data %v, want [%d]", i, inst.Data, tt.data)
+		}
+		if len(inst.Accounts) != len(tt.accounts) {
+			t.Errorf("inner instruction %d: accounts %v, want %d", i, inst.Accounts, len(tt.accounts))
+			continue
+		}
+		for j, key := range tt.accounts {
+			if inst.Accounts[j] != base58.Encode(testKey(key)) {
+				t.Errorf("inner instruction %d: account %d is %s, want key %#x", i, j, inst.Accounts[j], key)
+			}
+		}
+	}
+}
+
+func TestParseInstructionAccounts(t *testing.T) {
+	tx := withInnerInstructions(versionedTransaction(5))
+	// A second outer instruction without accounts adds no rows
+	tx.Transaction.Message.Instructions = append(tx.Transaction.Message.Instructions, &pb.CompiledInstruction{ProgramIdIndex: 3})
+	parsed, err := ParseTransaction(&pb.SubscribeUpdateTransaction{Slot: 100, Transaction: tx})
+	if err != nil {
+		t.Fatalf("ParseTransaction: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		position int
+		program  byte
+		key      byte
+		signer   bool
+		writable bool
+	}{
+		{"0", 0, 4, 1, true, true},
+		{"0", 1, 4, 3, false, true},
+		{"0.0", 0, 0x20, 0x10, false, true},
+		{"0.0", 1, 0x20, 2, true, false},
+		{"0.1", 0, 4, 0x22, false, false},
+	}
+	if len(parsed.InstructionAccounts) != len(tests) {
+		t.Fatalf("%d instruction accounts, want %d", len(parsed.InstructionAccounts), len(tests))
+	}
+	for i, tt := range tests {
+		account := parsed.InstructionAccounts[i]
+		if account.Slot != 100 || account.TransactionIndex != 5 {
+			t.Errorf("row %d: at slot %d transaction %d, want 100/5", i, account.Slot, account.TransactionIndex)
+		}
+		if account.InstructionPath != tt.path || account.Position != tt.position {
+			t.Errorf("row %d: path %s position %d, want %s %d", i, account.InstructionPath, account.Position, tt.path, tt.position)
+		}
+		if account.ProgramId != base58.Encode(testKey(tt.program)) || account.Pubkey != base58.Encode(testKey(tt.key)) {
+			t.Errorf("row %d: program %s pubkey %s, want keys %#x %#x", i, account.ProgramId, account.Pubkey, tt.program, tt.key)
+		}
+		if account.IsSigner != tt.signer || account.IsWritable != tt.writable {
+			t.Errorf("row %d: signer %v writable %v, want %v %v", i, account.IsSigner, account.IsWritable, tt.signer, tt.writable)
+		}
+	}
+}
+
+func TestParseCommission(t *testing.T) {
+	tests := []struct {
+		commission string
+		want       int // -1 for nil
+		wantErr    bool
+	}{
+		{"", -1, false},
+		{"0", 0, false},
+		{"10", 10, false},
+		{"100", 100, false},
+		{"255", 255, false},
+		{"256", 0, true},
+		{"-1", 0, true},
+		{"5%", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCommission(tt.commission)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCommission(%q): error %v, want error %v", tt.commission, err, tt.wantErr)
+			continue
+		}
+		switch {
+		case tt.wantErr:
+		case tt.want < 0 && got != nil:
+			t.Errorf("parseCommission(%q) = %d, want nil", tt.commission, *got)
+		case tt.want >= 0 && (got == nil || int(*got) != tt.want):
+			t.Errorf("parseCommission(%q) = %v, want %d", tt.commission, got, tt.want)
+		}
+	}
+}
+
+func TestRewardType(t *testing.T) {
+	tests := []struct {
+		in   pb.RewardType
+		want RewardType
+	}{
+		{pb.RewardType_Unspecified, RewardTypeUnspecified},
+		{pb.RewardType_Fee, RewardTypeFee},
+		{pb.RewardType_Rent, RewardTypeRent},
+		{pb.RewardType_Staking, RewardTypeStaking},
+		{pb.RewardType_Voting, RewardTypeVoting},
+		{pb.RewardType(42), RewardTypeUnspecified},
+	}
+	for _, tt := range tests {
+		if got := rewardType(tt.in); got != tt.want {
+			t.Errorf("rewardType(%v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRewards(t *testing.T) {
+	rewards := []*pb.Reward{
+		{Pubkey: "fee", Lamports: 5000, PostBalance: 10, RewardType: pb.RewardType_Fee},
+		{Pubkey: "vote", Lamports: 7, PostBalance: 20, RewardType: pb.RewardType_Voting, Commission: "8"},
+	}
+	meta := &pb.SubscribeUpdateBlockMeta{
+		Slot:    100,
+		Rewards: &pb.Rewards{Rewards: rewards, NumPartitions: &pb.NumPartitions{NumPartitions: 4}},
+	}
+	parsed, err := ParseBlockMeta(meta)
+	if err != nil {
+		t.Fatalf("ParseBlockMeta: %v", err)
+	}
+	if got := parsed.Block.NumPartitions; got == nil || *got != 4 {
+		t.Errorf("partitions %v, want 4", got)
+	}
+	if len(parsed.BlockRewards) != 2 {
+		t.Fatalf("%d block rewards, want 2", len(parsed.BlockRewards))
+	}
+	if reward := parsed.BlockRewards[0]; reward.RewardType != RewardTypeFee || reward.Commission != nil {
+		t.Errorf("fee reward %+v", reward)
+	}
+	if reward := parsed.BlockRewards[1]; reward.RewardIndex != 1 || reward.RewardType != RewardTypeVoting || reward.Commission == nil || *reward.Commission != 8 {
+		t.Errorf("voting reward %+v", reward)
+	}
+
+	meta.Rewards = &pb.Rewards{Rewards: rewards[:1]}
+	if parsed, err = ParseBlockMeta(meta); err != nil {
+		t.Fatalf("ParseBlockMeta: %v", err)
+	}
+	if parsed.Block.NumPartitions != nil {
+		t.Errorf("unpartitioned rewards: partitions %d, want nil", *parsed.Block.NumPartitions)
+	}
+
+	tx := testTransaction(3)
+	tx.Meta.Rewards = rewards
+	parsed, err = ParseTransaction(&pb.SubscribeUpdateTransaction{Slot: 100, Transaction: tx})
+	if err != nil {
+		t.Fatalf("ParseTransaction: %v", err)
+	}
+	if len(parsed.TransactionRewards) != 2 {
+		t.Fatalf("%d transaction rewards, want 2", len(parsed.TransactionRewards))
+	}
+	if reward := parsed.TransactionRewards[1]; reward.TransactionIndex != 3 || reward.RewardIndex != 1 || reward.Pubkey != "vote" || reward.Commission == nil {
+		t.Errorf("transaction reward %+v", reward)
+	}
+
+	tx.Meta.Rewards = []*pb.Reward{{Pubkey: "vote", RewardType: pb.RewardType_Voting, Commission: "300"}}
+	if _, err := ParseTransaction(&pb.SubscribeUpdateTransaction{Slot: 100, Transaction: tx}); err == nil {
+		t.Error("ParseTransaction accepted an out of range commission")
+	}
+}
+
+func TestNullTime(t *testing.T) {
+	if got := nullTime(time.Time{}); got != nil {
+		t.Errorf("nullTime(zero) = %v, want nil", got)
+	}
+	now := time.Unix(1700000000, 0)
+	if got := nullTime(now); got != now {
+		t.Errorf("nullTime(%v) = %v", now, got)
+	}
+}
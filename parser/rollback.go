@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// slotTables lists every table holding rows keyed by the slot of a block
+var slotTables = []string{
+	"blocks",
+	"block_rewards",
+	"block_entries",
+	"transactions",
+	"transactions_signatures",
+	"transaction_instructions",
+	"transaction_inner_instructions",
+	"transaction_logs",
+	"transaction_accounts",
+	"transaction_token_balances",
+	"transaction_instruction_accounts",
+	"transaction_rewards",
+}
+
+// RollbackSlots soft-deletes every row stored for the given slots. If the
+// pipeline checkpoint points at one of them it is re-pointed to the highest
+// live block below it, so resume and replays follow the canonical fork.
+func RollbackSlots(db *sql.DB, slots []uint64, pipeline string) error {
+	if len(slots) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	placeholders := strings.Repeat("?,", len(slots)-1) + "?"
+	args := make([]interface{}, 0, len(slots)+2)
+	args = append(args, now, now)
+	for _, slot := range slots {
+		args = append(args, slot)
+	}
+
+	for _, table := range slotTables {
+		_, err := tx.Exec(fmt.Sprintf(`
+			UPDATE %s SET deleted_at = ?, updated_at = ?
+			WHERE deleted_at IS NULL AND slot IN (%s)`, table, placeholders), args...)
+		if err != nil {
+			return fmt.Errorf("error rolling back %s: %v", table, err)
+		}
+	}
+
+	if pipeline != "" {
+		if err := repointCheckpoint(tx, pipeline, slots); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// repointCheckpoint moves a checkpoint that references a rolled back slot
+// back to the highest live block below that slot
+func repointCheckpoint(tx *sql.Tx, pipeline string, slots []uint64) error {
+	var lastSlot uint64
+	err := tx.QueryRow(`SELECT last_slot FROM ingest_checkpoints WHERE pipeline = ? FOR UPDATE`, pipeline).Scan(&lastSlot)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error locking checkpoint for pipeline %s: %v", pipeline, err)
+	}
+
+	rolledBack := false
+	for _, slot := range slots {
+		if slot == lastSlot {
+			rolledBack = true
+			break
+		}
+	}
+	if !rolledBack {
+		return nil
+	}
+
+	var slot uint64
+	var blockhash string
+	err = tx.QueryRow(`
+		SELECT slot, blockhash FROM blocks
+		WHERE deleted_at IS NULL AND slot < ?
+		ORDER BY slot DESC LIMIT 1`, lastSlot).Scan(&slot, &blockhash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error finding canonical block below %d: %v", lastSlot, err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE ingest_checkpoints
+		SET last_slot = ?, blockhash = ?, updated_at = ?
+		WHERE pipeline = ?`, slot, blockhash, time.Now(), pipeline)
+	if err != nil {
+		return fmt.Errorf("error re-pointing checkpoint for pipeline %s: %v", pipeline, err)
+	}
+	return nil
+}
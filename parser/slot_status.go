@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	pb "goblockstore/proto"
+)
+
+// ParseSlotStatus converts a slot update observed at seenAt into a timeline
+// row. Statuses other than processed, confirmed, finalized and dead only
+// contribute the parent slot.
+func ParseSlotStatus(update *pb.SubscribeUpdateSlot, seenAt time.Time) *SlotStatus {
+	now := time.Now()
+	status := &SlotStatus{
+		Slot:       update.Slot,
+		ParentSlot: update.Parent,
+		DeadError:  update.DeadError,
+		UpdatedAt:  now,
+		CreatedAt:  now,
+	}
+
+	switch update.Status {
+	case pb.CommitmentLevel_PROCESSED:
+		status.ProcessedAt = &seenAt
+	case pb.CommitmentLevel_CONFIRMED:
+		status.ConfirmedAt = &seenAt
+	case pb.CommitmentLevel_FINALIZED:
+		status.FinalizedAt = &seenAt
+	case pb.CommitmentLevel_DEAD:
+		status.DeadAt = &seenAt
+	}
+	if update.DeadError != nil && status.DeadAt == nil {
+		status.DeadAt = &seenAt
+	}
+
+	return status
+}
+
+// SaveSlotStatus merges a slot update into the slot timeline. Each timestamp
+// keeps the first time its status was seen.
+func SaveSlotStatus(db *sql.DB, status *SlotStatus) error {
+	_, err := db.Exec(`
+		INSERT INTO slot_status (
+			slot, parent_slot, processed_at, confirmed_at, finalized_at,
+			dead_at, dead_error, updated_at, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			parent_slot = COALESCE(parent_slot, VALUES(parent_slot)),
+			processed_at = COALESCE(processed_at, VALUES(processed_at)),
+			confirmed_at = COALESCE(confirmed_at, VALUES(confirmed_at)),
+			finalized_at = COALESCE(finalized_at, VALUES(finalized_at)),
+			dead_at = COALESCE(dead_at, VALUES(dead_at)),
+			dead_error = COALESCE(dead_error, VALUES(dead_error)),
+			updated_at = VALUES(updated_at)`,
+		status.Slot,
+		status.ParentSlot,
+		status.ProcessedAt,
+		status.ConfirmedAt,
+		status.FinalizedAt,
+		status.DeadAt,
+		status.DeadError,
+		status.UpdatedAt,
+		status.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving status of slot %d: %v", status.Slot, err)
+	}
+	return nil
+}
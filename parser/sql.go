@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // generateBatchInsertSQL generates an SQL statement for batch inserting multiple rows
@@ -24,21 +25,94 @@ func generateBatchInsertSQL(table string, columns []string, numRows int) string
 	return sql + strings.Join(placeholders, ",")
 }
 
+// maxPlaceholders is the most parameters MySQL-protocol servers accept in one statement
+const maxPlaceholders = 65535
+
+// execBatchInsert inserts rows of values into table, splitting statements
+// that would exceed the placeholder limit
+func execBatchInsert(tx *sql.Tx, table string, columns []string, values []interface{}) error {
+	chunk := (maxPlaceholders / len(columns)) * len(columns)
+	for start := 0; start < len(values); start += chunk {
+		end := min(start+chunk, len(values))
+		query := generateBatchInsertSQL(table, columns, (end-start)/len(columns))
+		if _, err := tx.Exec(query, values[start:end]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SaveToDatabase saves a parsed block to the database
 func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
+	_, err := SaveBlock(db, block, nil)
+	return err
+}
+
+// SaveBlock saves a parsed block and, when cursor is non-nil, advances that
+// pipeline's checkpoint in the same database transaction. A block whose slot
+// is already stored is skipped, so replays after a restart are idempotent.
+// It reports whether the block rows were written.
+func SaveBlock(db *sql.DB, block *ParsedBlock, cursor *Checkpoint) (bool, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %v", err)
+		return false, fmt.Errorf("error starting transaction: %v", err)
 	}
 	defer tx.Rollback()
 
+	if cursor != nil {
+		// Serialize writers of the same pipeline on its checkpoint row
+		if err := lockCheckpoint(tx, cursor.Pipeline); err != nil {
+			return false, err
+		}
+	}
+
+	exists, err := blockExists(tx, block.Block.Slot)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		if err := insertBlockRows(tx, block); err != nil {
+			return false, err
+		}
+	}
+
+	if cursor != nil {
+		cp := *cursor
+		cp.LastSlot = block.Block.Slot
+		cp.Blockhash = block.Block.Blockhash
+		if err := advanceCheckpoint(tx, &cp); err != nil {
+			return false, err
+		}
+	}
+
+	// Commit transaction
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return !exists, nil
+}
+
+// blockExists reports whether a live block row is already stored for slot
+func blockExists(tx *sql.Tx, slot uint64) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM blocks WHERE slot = ? AND deleted_at IS NULL`, slot).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for existing block: %v", err)
+	}
+	return count > 0, nil
+}
+
+// insertBlockRows writes the block and all of its child rows within tx
+func insertBlockRows(tx *sql.Tx, block *ParsedBlock) error {
 	// Save block
-	_, err = tx.Exec(`
+	_, err := tx.Exec(`
 		INSERT INTO blocks (
 			slot, parent_slot, block_time, block_height, blockhash, 
 			previous_blockhash, transaction_count, successful, 
+			executed_transaction_count, entries_count, num_partitions,
 			updated_at, created_at
-		) VALUES (?, ?, FROM_UNIXTIME(?), ?, ?, ?, ?, ?, ?, ?)`,
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		block.Block.Slot,
 		block.Block.ParentSlot,
 		block.Block.BlockTime,
@@ -47,6 +121,9 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 		block.Block.PreviousBlockhash,
 		block.Block.TransactionCount,
 		block.Block.Successful,
+		block.Block.ExecutedTransactionCount,
+		block.Block.EntriesCount,
+		block.Block.NumPartitions,
 		block.Block.UpdatedAt,
 		block.Block.CreatedAt,
 	)
@@ -54,32 +131,66 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 		return fmt.Errorf("error inserting block: %v", err)
 	}
 
+	// Save block entries in batches
+	if len(block.BlockEntries) > 0 {
+		columns := []string{
+			"slot", "entry_index", "num_hashes", "hash", "executed_transaction_count",
+			"starting_transaction_index", "updated_at", "created_at",
+		}
+		sql := generateBatchInsertSQL("block_entries", columns, len(block.BlockEntries))
+
+		values := make([]interface{}, 0, len(block.BlockEntries)*len(columns))
+		for _, entry := range block.BlockEntries {
+			values = append(values,
+				entry.Slot,
+				entry.EntryIndex,
+				entry.NumHashes,
+				entry.Hash,
+				entry.ExecutedTransactionCount,
+				entry.StartingTransactionIndex,
+				entry.UpdatedAt,
+				entry.CreatedAt,
+			)
+		}
+
+		_, err = tx.Exec(sql, values...)
+		if err != nil {
+			return fmt.Errorf("error batch inserting block entries: %v", err)
+		}
+	}
+
 	// Save block rewards in batches
-	// if len(block.BlockRewards) > 0 {
-	// 	columns := []string{"slot", "reward_index", "pubkey", "lamports", "post_balance",
-	// 		"reward_type", "commission", "updated_at", "created_at"}
-	// 	sql := generateBatchInsertSQL("block_rewards", columns, len(block.BlockRewards))
+	if len(block.BlockRewards) > 0 {
+		columns := []string{"slot", "reward_index", "pubkey", "lamports", "post_balance",
+			"reward_type", "commission", "updated_at", "created_at"}
 
-	// 	values := make([]interface{}, 0, len(block.BlockRewards)*len(columns))
-	// 	for _, reward := range block.BlockRewards {
-	// 		values = append(values,
-	// 			reward.Slot,
-	// 			reward.RewardIndex,
-	// 			reward.Pubkey,
-	// 			reward.Lamports,
-	// 			reward.PostBalance,
-	// 			reward.RewardType,
-	// 			reward.Commission,
-	// 			reward.UpdatedAt,
-	// 			reward.CreatedAt,
-	// 		)
-	// 	}
+		values := make([]interface{}, 0, len(block.BlockRewards)*len(columns))
+		for _, reward := range block.BlockRewards {
+			values = append(values,
+				reward.Slot,
+				reward.RewardIndex,
+				reward.Pubkey,
+				reward.Lamports,
+				reward.PostBalance,
+				reward.RewardType,
+				reward.Commission,
+				reward.UpdatedAt,
+				reward.CreatedAt,
+			)
+		}
 
-	// 	_, err = tx.Exec(sql, values...)
-	// 	if err != nil {
-	// 		return fmt.Errorf("error batch inserting block rewards: %v", err)
-	// 	}
-	// }
+		err = execBatchInsert(tx, "block_rewards", columns, values)
+		if err != nil {
+			return fmt.Errorf("error batch inserting block rewards: %v", err)
+		}
+	}
+
+	return insertTransactionRows(tx, block)
+}
+
+// insertTransactionRows writes the transactions of block and their child rows within tx
+func insertTransactionRows(tx *sql.Tx, block *ParsedBlock) error {
+	var err error
 
 	// Save transactions in batches
 	if len(block.Transactions) > 0 {
@@ -88,7 +199,7 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 			"compute_units_consumed", "compute_units_price", "err", "err_message",
 			"err_stack", "err_instruction_index", "err_custom_code", "err_custom_message",
 			"successful", "version", "recent_blockhash", "num_readonly_signed_accounts",
-			"num_readonly_unsigned_accounts", "num_required_signatures", "updated_at", "created_at",
+			"num_readonly_unsigned_accounts", "num_required_signatures", "entry_index", "updated_at", "created_at",
 		}
 		sql := generateBatchInsertSQL("transactions", columns, len(block.Transactions))
 
@@ -97,7 +208,7 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 			values = append(values,
 				tx.Slot,
 				tx.TransactionIndex,
-				tx.BlockTime,
+				nullTime(tx.BlockTime),
 				tx.BlockHash,
 				tx.Fee,
 				tx.ComputeUnitsConsumed,
@@ -114,6 +225,7 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 				tx.NumReadonlySignedAccounts,
 				tx.NumReadonlyUnsignedAccounts,
 				tx.NumRequiredSignatures,
+				tx.EntryIndex,
 				tx.UpdatedAt,
 				tx.CreatedAt,
 			)
@@ -148,36 +260,117 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 	}
 
 	// Save transaction instructions in batches
-	// if len(block.TransactionInstructions) > 0 {
-	// 	columns := []string{
-	// 		"slot", "transaction_index", "instruction_index", "program_id",
-	// 		"program_id_index", "stack_height", "data", "updated_at", "created_at",
-	// 	}
-	// 	sql := generateBatchInsertSQL("transaction_instructions", columns, len(block.TransactionInstructions))
+	if len(block.TransactionInstructions) > 0 {
+		columns := []string{
+			"slot", "transaction_index", "instruction_index", "program_id",
+			"program_id_index", "stack_height", "data", "updated_at", "created_at",
+		}
 
-	// 	values := make([]interface{}, 0, len(block.TransactionInstructions)*len(columns))
-	// 	for _, inst := range block.TransactionInstructions {
-	// 		// Convert instruction data to base58
-	// 		dataBase58 := base58.Encode(inst.Data)
+		values := make([]interface{}, 0, len(block.TransactionInstructions)*len(columns))
+		for _, inst := range block.TransactionInstructions {
+			values = append(values,
+				inst.Slot,
+				inst.TransactionIndex,
+				inst.InstructionIndex,
+				inst.ProgramId,
+				inst.ProgramIdIndex,
+				inst.StackHeight,
+				encodeBase58(inst.Data),
+				inst.UpdatedAt,
+				inst.CreatedAt,
+			)
+		}
 
-	// 		values = append(values,
-	// 			inst.Slot,
-	// 			inst.TransactionIndex,
-	// 			inst.InstructionIndex,
-	// 			inst.ProgramId,
-	// 			inst.ProgramIdIndex,
-	// 			inst.StackHeight,
-	// 			dataBase58,
-	// 			inst.UpdatedAt,
-	// 			inst.CreatedAt,
-	// 		)
-	// 	}
+		err = execBatchInsert(tx, "transaction_instructions", columns, values)
+		if err != nil {
+			return fmt.Errorf("error batch inserting transaction instructions: %v", err)
+		}
+	}
 
-	// 	_, err = tx.Exec(sql, values...)
-	// 	if err != nil {
-	// 		return fmt.Errorf("error batch inserting transaction instructions: %v", err)
-	// 	}
-	// }
+	// Save transaction rewards in batches
+	if len(block.TransactionRewards) > 0 {
+		columns := []string{"slot", "transaction_index", "reward_index", "pubkey", "lamports",
+			"post_balance", "reward_type", "commission", "updated_at", "created_at"}
+
+		values := make([]interface{}, 0, len(block.TransactionRewards)*len(columns))
+		for _, reward := range block.TransactionRewards {
+			values = append(values,
+				reward.Slot,
+				reward.TransactionIndex,
+				reward.RewardIndex,
+				reward.Pubkey,
+				reward.Lamports,
+				reward.PostBalance,
+				reward.RewardType,
+				reward.Commission,
+				reward.UpdatedAt,
+				reward.CreatedAt,
+			)
+		}
+
+		err = execBatchInsert(tx, "transaction_rewards", columns, values)
+		if err != nil {
+			return fmt.Errorf("error batch inserting transaction rewards: %v", err)
+		}
+	}
+
+	// Save transaction inner instructions in batches
+	if len(block.TransactionInnerInstructions) > 0 {
+		columns := []string{
+			"slot", "slot_index", "instruction_index", "inner_instruction_index",
+			"program_id", "program_id_index", "stack_height", "data", "updated_at", "created_at",
+		}
+
+		values := make([]interface{}, 0, len(block.TransactionInnerInstructions)*len(columns))
+		for _, inst := range block.TransactionInnerInstructions {
+			values = append(values,
+				inst.Slot,
+				inst.SlotIndex,
+				inst.InstructionIndex,
+				inst.InnerInstructionIndex,
+				inst.ProgramId,
+				inst.ProgramIdIndex,
+				inst.StackHeight,
+				encodeBase58(inst.Data),
+				inst.UpdatedAt,
+				inst.CreatedAt,
+			)
+		}
+
+		err = execBatchInsert(tx, "transaction_inner_instructions", columns, values)
+		if err != nil {
+			return fmt.Errorf("error batch inserting transaction inner instructions: %v", err)
+		}
+	}
+
+	// Save instruction accounts in batches
+	if len(block.InstructionAccounts) > 0 {
+		columns := []string{
+			"slot", "transaction_index", "instruction_path", "position", "program_id",
+			"pubkey", "is_signer", "is_writable", "updated_at", "created_at",
+		}
+
+		values := make([]interface{}, 0, len(block.InstructionAccounts)*len(columns))
+		for _, acc := range block.InstructionAccounts {
+			values = append(values,
+				acc.Slot,
+				acc.TransactionIndex,
+				acc.InstructionPath,
+				acc.Position,
+				acc.ProgramId,
+				acc.Pubkey,
+				acc.IsSigner,
+				acc.IsWritable,
+				acc.UpdatedAt,
+				acc.CreatedAt,
+			)
+		}
+
+		err = execBatchInsert(tx, "transaction_instruction_accounts", columns, values)
+		if err != nil {
+			return fmt.Errorf("error batch inserting instruction accounts: %v", err)
+		}
+	}
 
 	// // Save transaction logs in batches
 	// if len(block.TransactionLogs) > 0 {
@@ -207,41 +400,41 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 	// 	}
 	// }
 
-	// // Save transaction accounts in batches
-	// if len(block.TransactionAccounts) > 0 {
-	// 	columns := []string{
-	// 		"slot", "transaction_index", "account_index", "pubkey",
-	// 		"account_address", "is_signer", "is_writable", "pre_balance",
-	// 		"post_balance", "balance_change", "source", "rent_epoch_change",
-	// 		"updated_at", "created_at",
-	// 	}
-	// 	sql := generateBatchInsertSQL("transaction_accounts", columns, len(block.TransactionAccounts))
+	// Save transaction accounts in batches
+	if len(block.TransactionAccounts) > 0 {
+		columns := []string{
+			"slot", "transaction_index", "account_index", "pubkey",
+			"account_address", "is_signer", "is_writable", "pre_balance",
+			"post_balance", "balance_change", "source", "lookup_table", "rent_epoch_change",
+			"updated_at", "created_at",
+		}
 
-	// 	values := make([]interface{}, 0, len(block.TransactionAccounts)*len(columns))
-	// 	for _, acc := range block.TransactionAccounts {
-	// 		values = append(values,
-	// 			acc.Slot,
-	// 			acc.TransactionIndex,
-	// 			acc.AccountIndex,
-	// 			acc.Pubkey,
-	// 			acc.AccountAddress,
-	// 			acc.IsSigner,
-	// 			acc.IsWritable,
-	// 			acc.PreBalance,
-	// 			acc.PostBalance,
-	// 			acc.BalanceChange,
-	// 			acc.Source,
-	// 			acc.RentEpochChange,
-	// 			acc.UpdatedAt,
-	// 			acc.CreatedAt,
-	// 		)
-	// 	}
+		values := make([]interface{}, 0, len(block.TransactionAccounts)*len(columns))
+		for _, acc := range block.TransactionAccounts {
+			values = append(values,
+				acc.Slot,
+				acc.TransactionIndex,
+				acc.AccountIndex,
+				acc.Pubkey,
+				acc.AccountAddress,
+				acc.IsSigner,
+				acc.IsWritable,
+				acc.PreBalance,
+				acc.PostBalance,
+				acc.BalanceChange,
+				acc.Source,
+				acc.LookupTable,
+				acc.RentEpochChange,
+				acc.UpdatedAt,
+				acc.CreatedAt,
+			)
+		}
 
-	// 	_, err = tx.Exec(sql, values...)
-	// 	if err != nil {
-	// 		return fmt.Errorf("error batch inserting transaction accounts: %v", err)
-	// 	}
-	// }
+		err = execBatchInsert(tx, "transaction_accounts", columns, values)
+		if err != nil {
+			return fmt.Errorf("error batch inserting transaction accounts: %v", err)
+		}
+	}
 
 	// // Save transaction token balances in batches
 	// if len(block.TransactionTokenBalances) > 0 {
@@ -278,10 +471,103 @@ func SaveToDatabase(db *sql.DB, block *ParsedBlock) error {
 	// 	}
 	// }
 
+	return nil
+}
+
+// SaveProvisionalTransactions saves transactions streamed ahead of their block.
+// A transaction already stored at the same slot and index is skipped, so
+// replays after a reconnect are idempotent. It reports whether rows were written.
+func SaveProvisionalTransactions(db *sql.DB, block *ParsedBlock) (bool, error) {
+	if len(block.Transactions) == 0 {
+		return false, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM transactions
+		WHERE slot = ? AND transaction_index = ? AND deleted_at IS NULL`,
+		block.Block.Slot, block.Transactions[0].TransactionIndex).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for existing transaction: %v", err)
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	if err := insertTransactionRows(tx, block); err != nil {
+		return false, err
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %v", err)
+		return false, fmt.Errorf("error committing transaction: %v", err)
 	}
+	return true, nil
+}
 
-	return nil
+// ProvisionalSlots returns the slots holding provisional transactions that
+// are still waiting for their block, with how many each holds
+func ProvisionalSlots(db *sql.DB) (map[uint64]int, error) {
+	rows, err := db.Query(`
+		SELECT slot, COUNT(*) FROM transactions
+		WHERE block_hash = '' AND deleted_at IS NULL
+		GROUP BY slot`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying provisional transactions: %v", err)
+	}
+	defer rows.Close()
+
+	slots := make(map[uint64]int)
+	for rows.Next() {
+		var slot uint64
+		var count int
+		if err := rows.Scan(&slot, &count); err != nil {
+			return nil, fmt.Errorf("error scanning provisional transactions: %v", err)
+		}
+		slots[slot] = count
+	}
+	return slots, rows.Err()
+}
+
+// ReconcileStored reconciles provisional transactions whose block was saved
+// without them being updated, e.g. when the process stopped in between. It
+// returns how many were updated.
+func ReconcileStored(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE transactions t
+		JOIN blocks b ON b.slot = t.slot AND b.deleted_at IS NULL
+		SET t.block_hash = b.blockhash, t.block_time = b.block_time, t.updated_at = ?
+		WHERE t.block_hash = '' AND t.deleted_at IS NULL`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("error reconciling stored transactions: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// nullTime returns nil for the zero time, so unknown times are stored as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// ReconcileSlot fills in the block hash and time of the provisional
+// transactions stored for the slot of block. It returns how many were updated.
+func ReconcileSlot(db *sql.DB, block *Block) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE transactions
+		SET block_hash = ?, block_time = ?, updated_at = ?
+		WHERE slot = ? AND block_hash = '' AND deleted_at IS NULL`,
+		block.Blockhash, block.BlockTime, time.Now(), block.Slot)
+	if err != nil {
+		return 0, fmt.Errorf("error reconciling transactions of slot %d: %v", block.Slot, err)
+	}
+	return result.RowsAffected()
 }
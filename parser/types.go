@@ -20,8 +20,26 @@ type Block struct {
 	UpdatedAt         time.Time  `db:"updated_at"`
 	CreatedAt         time.Time  `db:"created_at"`
 	DeletedAt         *time.Time `db:"deleted_at"`
+
+	// ExecutedTransactionCount includes vote transactions
+	ExecutedTransactionCount uint64 `db:"executed_transaction_count"`
+	EntriesCount             uint64 `db:"entries_count"`
+	// NumPartitions is set for blocks paying out partitioned epoch rewards
+	NumPartitions *uint64 `db:"num_partitions"`
 }
 
+// RewardType is the kind of a reward, stored by its Solana name
+type RewardType string
+
+// Reward types
+const (
+	RewardTypeUnspecified RewardType = "Unspecified"
+	RewardTypeFee         RewardType = "Fee"
+	RewardTypeRent        RewardType = "Rent"
+	RewardTypeStaking     RewardType = "Staking"
+	RewardTypeVoting      RewardType = "Voting"
+)
+
 // BlockReward represents a reward in a Solana block
 type BlockReward struct {
 	Slot        uint64     `db:"slot"`
@@ -29,13 +47,28 @@ type BlockReward struct {
 	Pubkey      string     `db:"pubkey"`
 	Lamports    int64      `db:"lamports"`
 	PostBalance uint64     `db:"post_balance"`
-	RewardType  string     `db:"reward_type"`
-	Commission  *int64     `db:"commission"`
+	RewardType  RewardType `db:"reward_type"`
+	Commission  *uint8     `db:"commission"`
 	UpdatedAt   time.Time  `db:"updated_at"`
 	CreatedAt   time.Time  `db:"created_at"`
 	DeletedAt   *time.Time `db:"deleted_at"`
 }
 
+// TransactionReward represents a reward paid out by a Solana transaction
+type TransactionReward struct {
+	Slot             uint64     `db:"slot"`
+	TransactionIndex int        `db:"transaction_index"`
+	RewardIndex      int        `db:"reward_index"`
+	Pubkey           string     `db:"pubkey"`
+	Lamports         int64      `db:"lamports"`
+	PostBalance      uint64     `db:"post_balance"`
+	RewardType       RewardType `db:"reward_type"`
+	Commission       *uint8     `db:"commission"`
+	UpdatedAt        time.Time  `db:"updated_at"`
+	CreatedAt        time.Time  `db:"created_at"`
+	DeletedAt        *time.Time `db:"deleted_at"`
+}
+
 type Error struct {
 	Data any `json:"data,omitempty"`
 }
@@ -89,11 +122,25 @@ type Transaction struct {
 	NumReadonlySignedAccounts   uint32            `db:"num_readonly_signed_accounts"`
 	NumReadonlyUnsignedAccounts uint32            `db:"num_readonly_unsigned_accounts"`
 	NumRequiredSignatures       uint32            `db:"num_required_signatures"`
+	EntryIndex                  *int64            `db:"entry_index"`
 	UpdatedAt                   time.Time         `db:"updated_at"`
 	CreatedAt                   time.Time         `db:"created_at"`
 	DeletedAt                   *time.Time        `db:"deleted_at"`
 }
 
+// BlockEntry represents a PoH entry of a Solana block
+type BlockEntry struct {
+	Slot                     uint64     `db:"slot"`
+	EntryIndex               uint64     `db:"entry_index"`
+	NumHashes                uint64     `db:"num_hashes"`
+	Hash                     string     `db:"hash"`
+	ExecutedTransactionCount uint64     `db:"executed_transaction_count"`
+	StartingTransactionIndex uint64     `db:"starting_transaction_index"`
+	UpdatedAt                time.Time  `db:"updated_at"`
+	CreatedAt                time.Time  `db:"created_at"`
+	DeletedAt                *time.Time `db:"deleted_at"`
+}
+
 // Instruction represents a parsed Solana instruction
 type Instruction struct {
 	Slot             uint64     `db:"slot"`
@@ -106,6 +153,8 @@ type Instruction struct {
 	UpdatedAt        time.Time  `db:"updated_at"`
 	CreatedAt        time.Time  `db:"created_at"`
 	DeletedAt        *time.Time `db:"deleted_at"`
+	// Accounts are resolved against the full account list
+	Accounts []string `db:"-"`
 }
 
 // TransactionLog represents a log entry in a Solana transaction
@@ -134,6 +183,7 @@ type TransactionAccount struct {
 	PostBalance      uint64     `db:"post_balance"`
 	BalanceChange    int64      `db:"balance_change"`
 	Source           string     `db:"source"`
+	LookupTable      *string    `db:"lookup_table"`
 	RentEpochChange  int64      `db:"rent_epoch_change"`
 	UpdatedAt        time.Time  `db:"updated_at"`
 	CreatedAt        time.Time  `db:"created_at"`
@@ -148,10 +198,30 @@ type TransactionInnerInstruction struct {
 	InnerInstructionIndex int        `db:"inner_instruction_index"`
 	ProgramId             string     `db:"program_id"`
 	ProgramIdIndex        int        `db:"program_id_index"`
+	StackHeight           *int64     `db:"stack_height"`
 	Data                  []byte     `db:"data"`
 	UpdatedAt             time.Time  `db:"updated_at"`
 	CreatedAt             time.Time  `db:"created_at"`
 	DeletedAt             *time.Time `db:"deleted_at"`
+	// Accounts are resolved against the full account list
+	Accounts []string `db:"-"`
+}
+
+// InstructionAccount is one account passed to an outer or inner instruction.
+// InstructionPath is the outer instruction index, followed by the inner
+// instruction ordinal for CPIs, e.g. "2" or "2.0".
+type InstructionAccount struct {
+	Slot             uint64     `db:"slot"`
+	TransactionIndex int        `db:"transaction_index"`
+	InstructionPath  string     `db:"instruction_path"`
+	Position         int        `db:"position"`
+	ProgramId        string     `db:"program_id"`
+	Pubkey           string     `db:"pubkey"`
+	IsSigner         bool       `db:"is_signer"`
+	IsWritable       bool       `db:"is_writable"`
+	UpdatedAt        time.Time  `db:"updated_at"`
+	CreatedAt        time.Time  `db:"created_at"`
+	DeletedAt        *time.Time `db:"deleted_at"`
 }
 
 // TransactionTokenBalance represents a token balance in a Solana transaction
@@ -183,15 +253,55 @@ type TransactionSignature struct {
 	DeletedAt        *time.Time `db:"deleted_at"`
 }
 
+// AccountUpdate represents a single write to a Solana account
+type AccountUpdate struct {
+	Slot         uint64    `db:"slot"`
+	Pubkey       string    `db:"pubkey"`
+	Owner        string    `db:"owner"`
+	Lamports     uint64    `db:"lamports"`
+	Data         []byte    `db:"data"`
+	Executable   bool      `db:"executable"`
+	RentEpoch    uint64    `db:"rent_epoch"`
+	WriteVersion uint64    `db:"write_version"`
+	TxnSignature *string   `db:"txn_signature"`
+	UpdatedAt    time.Time `db:"updated_at"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// SlotStatus is the commitment timeline of a single slot
+type SlotStatus struct {
+	Slot        uint64     `db:"slot"`
+	ParentSlot  *uint64    `db:"parent_slot"`
+	ProcessedAt *time.Time `db:"processed_at"`
+	ConfirmedAt *time.Time `db:"confirmed_at"`
+	FinalizedAt *time.Time `db:"finalized_at"`
+	DeadAt      *time.Time `db:"dead_at"`
+	DeadError   *string    `db:"dead_error"`
+	UpdatedAt   time.Time  `db:"updated_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
+
+// Checkpoint records how far an ingestion pipeline has durably progressed
+type Checkpoint struct {
+	Pipeline   string    `db:"pipeline"`
+	LastSlot   uint64    `db:"last_slot"`
+	Blockhash  string    `db:"blockhash"`
+	Commitment string    `db:"commitment"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
 // ParsedBlock represents all data parsed from a Solana block
 type ParsedBlock struct {
 	Block                        Block
 	BlockRewards                 []BlockReward
+	TransactionRewards           []TransactionReward
+	BlockEntries                 []BlockEntry
 	Transactions                 []Transaction
 	TransactionLogs              []TransactionLog
 	TransactionAccounts          []TransactionAccount
 	TransactionInstructions      []Instruction
 	TransactionInnerInstructions []TransactionInnerInstruction
+	InstructionAccounts          []InstructionAccount
 	TransactionTokenBalances     []TransactionTokenBalance
 	TransactionSignatures        []TransactionSignature
 }
@@ -0,0 +1,87 @@
+package sigtracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// maxWait caps how long a status request may block waiting for a result
+const maxWait = 60 * time.Second
+
+// registerRequest is the body of POST /signatures
+type registerRequest struct {
+	Signature string `json:"signature"`
+	Blockhash string `json:"blockhash"`
+}
+
+// Handler serves the tracker over HTTP:
+//
+//	POST /signatures              register {"signature": ..., "blockhash": ...}
+//	GET  /signatures/{signature}  current result; ?wait=30s blocks until it finishes
+func (t *Tracker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /signatures", t.handleRegister)
+	mux.HandleFunc("GET /signatures/{signature}", t.handleStatus)
+	return mux
+}
+
+func (t *Tracker) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	result, err := t.Track(req.Signature, req.Blockhash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, result)
+}
+
+func (t *Tracker) handleStatus(w http.ResponseWriter, r *http.Request) {
+	signature := r.PathValue("signature")
+	result, ok := t.Lookup(signature)
+	if !ok {
+		http.Error(w, "signature not tracked", http.StatusNotFound)
+		return
+	}
+
+	if wait := r.URL.Query().Get("wait"); wait != "" && result.Status == StatusPending {
+		timeout, err := time.ParseDuration(wait)
+		if err != nil {
+			http.Error(w, "invalid wait duration", http.StatusBadRequest)
+			return
+		}
+		if timeout > maxWait {
+			timeout = maxWait
+		}
+
+		ch, err := t.Register(signature, result.Blockhash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case result = <-ch:
+		case <-timer.C:
+			t.Unregister(signature, ch)
+			result, _ = t.Lookup(signature)
+		case <-r.Context().Done():
+			t.Unregister(signature, ch)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
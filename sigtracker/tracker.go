@@ -0,0 +1,367 @@
+package sigtracker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+// Status of a tracked signature
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusLanded  Status = "landed"
+	StatusExpired Status = "expired"
+)
+
+// Tracker defaults
+const (
+	DefaultCheckInterval = 2 * time.Second
+	DefaultRetention     = 10 * time.Minute
+	// DefaultRecentSlots is how many slots of statuses are kept for
+	// signatures registered just after they landed
+	DefaultRecentSlots = 150
+	// expiryConfirmations is how many consecutive invalid checks of a
+	// blockhash are needed before its signatures expire, which covers a
+	// status update racing the RPC answer
+	expiryConfirmations = 2
+)
+
+// Result describes the outcome of a tracked signature
+type Result struct {
+	Signature string    `json:"signature"`
+	Blockhash string    `json:"blockhash"`
+	Status    Status    `json:"status"`
+	Slot      uint64    `json:"slot,omitempty"`
+	Index     uint64    `json:"index,omitempty"`
+	Err       *string   `json:"err,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// entry is a tracked signature and the callers waiting on it
+type entry struct {
+	result   Result
+	watchers []chan Result
+}
+
+// Tracker reports when registered transaction signatures land, using
+// TransactionsStatus updates, or expire, once their recent blockhash is no
+// longer valid according to the IsBlockhashValid RPC. When the subscription
+// follows Pending, signatures should be registered before they are sent, as
+// statuses from before the registration are only seen by chance.
+type Tracker struct {
+	Client        pb.GeyserClient
+	Commitment    pb.CommitmentLevel
+	CheckInterval time.Duration
+	Retention     time.Duration
+	RecentSlots   uint64
+	// Changed, when set, is called after the set of pending signatures
+	// changes, so the status subscription can be narrowed to them
+	Changed func()
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// invalid counts consecutive failed validity checks per blockhash
+	invalid map[string]int
+	// recent holds the statuses of untracked signatures seen in the last
+	// RecentSlots slots, indexed by slot for pruning
+	recent       map[string]Result
+	recentBySlot map[uint64][]string
+	highestSlot  uint64
+}
+
+// NewTracker creates a tracker that checks blockhash validity through client
+func NewTracker(client pb.GeyserClient, commitment pb.CommitmentLevel) *Tracker {
+	return &Tracker{
+		Client:        client,
+		Commitment:    commitment,
+		CheckInterval: DefaultCheckInterval,
+		Retention:     DefaultRetention,
+		RecentSlots:   DefaultRecentSlots,
+		entries:       make(map[string]*entry),
+		invalid:       make(map[string]int),
+		recent:        make(map[string]Result),
+		recentBySlot:  make(map[uint64][]string),
+	}
+}
+
+// Register starts tracking a signature sent with recentBlockhash. The returned
+// channel receives a single result once the signature lands or expires. If
+// the signature already finished, or landed in the last RecentSlots slots,
+// its result is delivered immediately.
+func (t *Tracker) Register(signature, recentBlockhash string) (<-chan Result, error) {
+	ch := make(chan Result, 1)
+	if _, err := t.track(signature, recentBlockhash, ch); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Track starts tracking a signature like Register, for callers that poll
+// Lookup instead of waiting, and returns its current result
+func (t *Tracker) Track(signature, recentBlockhash string) (Result, error) {
+	return t.track(signature, recentBlockhash, nil)
+}
+
+// track adds an entry for signature unless it exists and, when ch is set,
+// delivers the result to ch once the signature finishes
+func (t *Tracker) track(signature, recentBlockhash string, ch chan Result) (Result, error) {
+	if _, err := base58.Decode(signature); err != nil || signature == "" {
+		return Result{}, fmt.Errorf("invalid signature %q", signature)
+	}
+	if _, err := base58.Decode(recentBlockhash); err != nil || recentBlockhash == "" {
+		return Result{}, fmt.Errorf("invalid blockhash %q", recentBlockhash)
+	}
+
+	t.mu.Lock()
+	e, ok := t.entries[signature]
+	if !ok {
+		e = &entry{result: Result{
+			Signature: signature,
+			Blockhash: recentBlockhash,
+			Status:    StatusPending,
+			UpdatedAt: time.Now(),
+		}}
+		if landed, ok := t.recent[signature]; ok {
+			landed.Blockhash = recentBlockhash
+			landed.UpdatedAt = time.Now()
+			e.result = landed
+		}
+		t.entries[signature] = e
+	}
+	result := e.result
+	switch {
+	case result.Status != StatusPending && ch != nil:
+		ch <- result
+	case ch != nil:
+		e.watchers = append(e.watchers, ch)
+	}
+	t.mu.Unlock()
+
+	if !ok && result.Status == StatusPending {
+		t.changed()
+	}
+	return result, nil
+}
+
+// Unregister stops delivering a result to ch, for callers that gave up waiting
+func (t *Tracker) Unregister(signature string, ch <-chan Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[signature]
+	if !ok {
+		return
+	}
+	for i, watcher := range e.watchers {
+		if (<-chan Result)(watcher) == ch {
+			e.watchers = append(e.watchers[:i], e.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Lookup returns the current result of a tracked signature
+func (t *Tracker) Lookup(signature string) (Result, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[signature]
+	if !ok {
+		return Result{}, false
+	}
+	return e.result, true
+}
+
+// Pending returns the signatures still waiting to land or expire, sorted
+func (t *Tracker) Pending() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var pending []string
+	for signature, e := range t.entries {
+		if e.result.Status == StatusPending {
+			pending = append(pending, signature)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// OnStatus handles a transaction status update from the Geyser stream
+func (t *Tracker) OnStatus(update *pb.SubscribeUpdateTransactionStatus) {
+	signature := base58.Encode(update.Signature)
+
+	landed := Result{
+		Signature: signature,
+		Status:    StatusLanded,
+		Slot:      update.Slot,
+		Index:     update.Index,
+	}
+	if update.Err != nil {
+		errStr := string(update.Err.Err)
+		landed.Err = &errStr
+	}
+
+	t.mu.Lock()
+	e, ok := t.entries[signature]
+	if !ok {
+		t.remember(landed)
+		t.mu.Unlock()
+		return
+	}
+	if e.result.Status != StatusPending {
+		t.mu.Unlock()
+		return
+	}
+	landed.Blockhash = e.result.Blockhash
+	e.result = landed
+	t.finish(e)
+	t.mu.Unlock()
+
+	t.changed()
+}
+
+// remember keeps the status of an untracked signature for RecentSlots slots.
+// t.mu must be held.
+func (t *Tracker) remember(result Result) {
+	if result.Slot+t.RecentSlots < t.highestSlot {
+		return
+	}
+	t.recent[result.Signature] = result
+	t.recentBySlot[result.Slot] = append(t.recentBySlot[result.Slot], result.Signature)
+
+	if result.Slot <= t.highestSlot {
+		return
+	}
+	t.highestSlot = result.Slot
+	for slot, signatures := range t.recentBySlot {
+		if slot+t.RecentSlots < t.highestSlot {
+			for _, signature := range signatures {
+				delete(t.recent, signature)
+			}
+			delete(t.recentBySlot, slot)
+		}
+	}
+}
+
+// Run checks pending blockhashes for expiry and purges finished signatures
+// until ctx is cancelled
+func (t *Tracker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		for _, blockhash := range t.pendingBlockhashes() {
+			if err := t.checkBlockhash(ctx, blockhash); err != nil {
+				log.Printf("Failed to check blockhash %s: %v", blockhash, err)
+			}
+		}
+		t.purge()
+	}
+}
+
+// pendingBlockhashes returns the distinct blockhashes of pending signatures
+func (t *Tracker) pendingBlockhashes() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var result []string
+	for _, e := range t.entries {
+		if e.result.Status == StatusPending && !seen[e.result.Blockhash] {
+			seen[e.result.Blockhash] = true
+			result = append(result, e.result.Blockhash)
+		}
+	}
+	return result
+}
+
+// checkBlockhash expires the pending signatures of a blockhash once it has
+// been reported invalid enough times in a row
+func (t *Tracker) checkBlockhash(ctx context.Context, blockhash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	commitment := t.Commitment
+	resp, err := t.Client.IsBlockhashValid(ctx, &pb.IsBlockhashValidRequest{
+		Blockhash:  blockhash,
+		Commitment: &commitment,
+	})
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if resp.Valid {
+		delete(t.invalid, blockhash)
+		t.mu.Unlock()
+		return nil
+	}
+	t.invalid[blockhash]++
+	if t.invalid[blockhash] < expiryConfirmations {
+		t.mu.Unlock()
+		return nil
+	}
+
+	delete(t.invalid, blockhash)
+	expired := false
+	for _, e := range t.entries {
+		if e.result.Blockhash == blockhash && e.result.Status == StatusPending {
+			e.result.Status = StatusExpired
+			e.result.Slot = resp.Slot
+			t.finish(e)
+			expired = true
+		}
+	}
+	t.mu.Unlock()
+
+	if expired {
+		t.changed()
+	}
+	return nil
+}
+
+// changed reports a change of the pending signatures. t.mu must not be held,
+// as Changed reads them back through Pending.
+func (t *Tracker) changed() {
+	if t.Changed != nil {
+		t.Changed()
+	}
+}
+
+// finish notifies the watchers of a finished entry. t.mu must be held.
+func (t *Tracker) finish(e *entry) {
+	e.result.UpdatedAt = time.Now()
+	for _, ch := range e.watchers {
+		ch <- e.result
+	}
+	e.watchers = nil
+}
+
+// purge forgets signatures that finished longer than Retention ago
+func (t *Tracker) purge() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-t.Retention)
+	for signature, e := range t.entries {
+		if e.result.Status != StatusPending && e.result.UpdatedAt.Before(cutoff) {
+			delete(t.entries, signature)
+		}
+	}
+}
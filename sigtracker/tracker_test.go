@@ -0,0 +1,121 @@
+package sigtracker
+
+import (
+	"strings"
+	"testing"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+// testSignature returns a 64-byte signature starting with b
+func testSignature(b byte) []byte {
+	sig := make([]byte, 64)
+	sig[0] = b
+	return sig
+}
+
+const testBlockhash = "11111111111111111111111111111111"
+
+func TestRegisterAfterStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		landedSlot uint64
+		laterSlot  uint64
+		want       Status
+	}{
+		{"just landed", 100, 100, StatusLanded},
+		{"within recent slots", 100, 100 + DefaultRecentSlots, StatusLanded},
+		{"beyond recent slots", 100, 101 + DefaultRecentSlots, StatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(nil, pb.CommitmentLevel_CONFIRMED)
+			sig := testSignature(1)
+			tracker.OnStatus(&pb.SubscribeUpdateTransactionStatus{Slot: tt.landedSlot, Signature: sig, Index: 4})
+			tracker.OnStatus(&pb.SubscribeUpdateTransactionStatus{Slot: tt.laterSlot, Signature: testSignature(2)})
+
+			ch, err := tracker.Register(base58.Encode(sig), testBlockhash)
+			if err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			select {
+			case result := <-ch:
+				if result.Status != tt.want || result.Slot != tt.landedSlot || result.Index != 4 {
+					t.Errorf("result %+v, want %s at slot %d", result, tt.want, tt.landedSlot)
+				}
+			default:
+				if tt.want != StatusPending {
+					t.Errorf("no result delivered, want %s", tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	tracker := NewTracker(nil, pb.CommitmentLevel_CONFIRMED)
+	signature := base58.Encode(testSignature(1))
+
+	first, _ := tracker.Register(signature, testBlockhash)
+	second, _ := tracker.Register(signature, testBlockhash)
+	tracker.Unregister(signature, first)
+
+	if n := len(tracker.entries[signature].watchers); n != 1 {
+		t.Fatalf("%d watchers after unregistering one of two", n)
+	}
+	tracker.OnStatus(&pb.SubscribeUpdateTransactionStatus{Slot: 5, Signature: testSignature(1)})
+	if result := <-second; result.Status != StatusLanded {
+		t.Errorf("remaining watcher got %s, want %s", result.Status, StatusLanded)
+	}
+	select {
+	case result := <-first:
+		t.Errorf("unregistered watcher got %+v", result)
+	default:
+	}
+}
+
+func TestTrackCreatesNoWatcher(t *testing.T) {
+	tracker := NewTracker(nil, pb.CommitmentLevel_CONFIRMED)
+	signature := base58.Encode(testSignature(1))
+
+	for i := 0; i < 2; i++ {
+		result, err := tracker.Track(signature, testBlockhash)
+		if err != nil || result.Status != StatusPending {
+			t.Fatalf("Track: %+v, %v", result, err)
+		}
+	}
+	if n := len(tracker.entries[signature].watchers); n != 0 {
+		t.Errorf("%d watchers after Track", n)
+	}
+	if _, err := tracker.Track("", testBlockhash); err == nil {
+		t.Error("Track accepted an empty signature")
+	}
+}
+
+func TestChangedFollowsPending(t *testing.T) {
+	tracker := NewTracker(nil, pb.CommitmentLevel_CONFIRMED)
+	var seen [][]string
+	tracker.Changed = func() { seen = append(seen, tracker.Pending()) }
+
+	first, second := base58.Encode(testSignature(1)), base58.Encode(testSignature(2))
+	tracker.Track(first, testBlockhash)
+	tracker.Register(second, testBlockhash)
+	// Already tracked, so the pending set is unchanged
+	tracker.Register(first, testBlockhash)
+	tracker.OnStatus(&pb.SubscribeUpdateTransactionStatus{Slot: 5, Signature: testSignature(1)})
+	// Untracked statuses and repeats change nothing either
+	tracker.OnStatus(&pb.SubscribeUpdateTransactionStatus{Slot: 5, Signature: testSignature(3)})
+	tracker.OnStatus(&pb.SubscribeUpdateTransactionStatus{Slot: 6, Signature: testSignature(1)})
+
+	want := [][]string{{first}, {first, second}, {second}}
+	if len(seen) != len(want) {
+		t.Fatalf("Changed called with %v, want %v", seen, want)
+	}
+	for i := range want {
+		if strings.Join(seen[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("change %d: pending %v, want %v", i, seen[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package spool
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"goblockstore/ingest"
+	pb "goblockstore/proto"
+)
+
+// retryDelay is how long the drain waits after failing to read the spool
+const retryDelay = time.Second
+
+// metrics exposes the spool size and backlog under /debug/vars
+var metrics = expvar.NewMap("spool")
+
+// Buffer sits in front of the ingest pipeline. Updates go straight to the
+// pipeline while it keeps up; once its queues are full they are spooled to
+// disk instead, and Run feeds them back in order as the database recovers.
+// Handle never blocks on the pipeline, so the stream keeps being received
+// during an outage.
+type Buffer struct {
+	Spool    *Spool
+	Pipeline *ingest.Pipeline
+
+	mu sync.Mutex
+	// draining is set while a spooled update is on its way into the pipeline
+	draining bool
+}
+
+// NewBuffer creates a buffer spooling to spool
+func NewBuffer(spool *Spool, pipeline *ingest.Pipeline) *Buffer {
+	metrics.Set("bytes", expvar.Func(func() any { return spool.Size() }))
+	metrics.Set("pending", expvar.Func(func() any { return spool.Pending() }))
+	return &Buffer{Spool: spool, Pipeline: pipeline}
+}
+
+// Handle passes an update on, spooling it when the pipeline has no room.
+// A full spool is returned as an error so the stream is resubscribed later.
+func (b *Buffer) Handle(update *pb.SubscribeUpdate) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Earlier updates still waiting on disk must be written first
+	if b.Spool.Pending() == 0 && !b.draining && b.Pipeline.TrySubmit(update) {
+		return nil
+	}
+	return b.Spool.Append(update)
+}
+
+// LastSlot returns the highest slot spooled, so a resubscription continues
+// after the spool rather than after the database checkpoint
+func (b *Buffer) LastSlot() (uint64, bool) {
+	return b.Spool.LastSlot()
+}
+
+// Run drains spooled updates into the pipeline until ctx is cancelled. Each
+// update is acknowledged once written, so a restart resumes the drain.
+func (b *Buffer) Run(ctx context.Context) {
+	for {
+		// Leave the rest on disk once shutdown begins; it is drained on restart
+		if ctx.Err() != nil {
+			return
+		}
+
+		b.mu.Lock()
+		update, pos, ok, err := b.Spool.Next()
+		b.draining = ok
+		b.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Failed to read spooled update: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.Spool.Notify():
+			}
+			continue
+		}
+
+		err = b.Pipeline.SubmitThen(update, func() {
+			if err := b.Spool.Ack(pos); err != nil {
+				log.Printf("Failed to acknowledge spooled update: %v", err)
+			}
+		})
+
+		b.mu.Lock()
+		b.draining = false
+		b.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package spool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"goblockstore/ingest"
+	pb "goblockstore/proto"
+)
+
+func TestBufferSpoolsWhilePipelineStalls(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	// Every write blocks until the database "recovers"
+	writing := make(chan struct{}, 1)
+	recovered := make(chan struct{})
+	pipeline := &ingest.Pipeline{
+		Prepare: func(*pb.SubscribeUpdate) func() error {
+			return func() error {
+				select {
+				case writing <- struct{}{}:
+				default:
+				}
+				<-recovered
+				return nil
+			}
+		},
+		ParseWorkers: 1,
+		ParseQueue:   1,
+		WriteQueue:   1,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pipeline.Run(ctx)
+	defer close(recovered)
+
+	// Stall the writer on a first update, then fill the queues behind it
+	if err := pipeline.Submit(&pb.SubscribeUpdate{}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-writing
+	for pipeline.TrySubmit(&pb.SubscribeUpdate{}) {
+	}
+
+	b := &Buffer{Spool: s, Pipeline: pipeline}
+	slot := &pb.SubscribeUpdate{UpdateOneof: &pb.SubscribeUpdate_Slot{Slot: &pb.SubscribeUpdateSlot{Slot: 41}}}
+	handled := make(chan error, 1)
+	go func() {
+		if err := b.Handle(slot); err != nil {
+			handled <- err
+			return
+		}
+		handled <- b.Handle(blockUpdate(42))
+	}()
+
+	select {
+	case err := <-handled:
+		if err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle blocked on the stalled pipeline")
+	}
+	if got := s.Pending(); got != 2 {
+		t.Errorf("%d updates spooled, want 2", got)
+	}
+	if last, ok := s.LastSlot(); !ok || last != 42 {
+		t.Errorf("last spooled slot %d, want 42", last)
+	}
+	update, _, _, err := s.Next()
+	if err != nil || update.GetSlot().GetSlot() != 41 {
+		t.Errorf("first spooled update %v, %v; want slot 41", update, err)
+	}
+}
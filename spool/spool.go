@@ -0,0 +1,422 @@
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "goblockstore/proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Spool defaults
+const (
+	DefaultMaxBytes    = 10 << 30
+	DefaultSegmentSize = 64 << 20
+)
+
+// ErrFull is returned by Append when an update would exceed the size cap
+var ErrFull = errors.New("spool is full")
+
+// segmentExt names segment files, which are called after their sequence number
+const segmentExt = ".seg"
+
+// cursorFile persists the position up to which updates were written to the DB
+const cursorFile = "cursor"
+
+// recordHeader is a big-endian length followed by a CRC32 of the payload
+const recordHeader = 8
+
+// Position locates a record in the spool
+type Position struct {
+	Segment uint64
+	Offset  int64
+}
+
+// Spool is a disk-backed FIFO of raw updates. Updates are appended to segment
+// files as length-prefixed protobufs; a cursor file records how far they
+// were written to the database so the spool survives restarts.
+type Spool struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+
+	mu       sync.Mutex
+	segments []uint64
+	sizes    map[uint64]int64
+	total    int64
+	writer   *os.File
+	read     Position
+	acked    Position
+	pending  int
+	lastSlot uint64
+	notify   chan struct{}
+}
+
+// Open opens or creates the spool in dir, capped at maxBytes on disk. A
+// record torn by a crash is cut off the end of the last segment.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating spool directory: %v", err)
+	}
+
+	s := &Spool{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		segmentSize: min(DefaultSegmentSize, maxBytes),
+		sizes:       make(map[uint64]int64),
+		notify:      make(chan struct{}, 1),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing spool directory: %v", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, seq)
+	}
+	sort.Slice(s.segments, func(a, b int) bool { return s.segments[a] < s.segments[b] })
+
+	if err := s.loadCursor(); err != nil {
+		return nil, err
+	}
+
+	// Count the unread records and find the last spooled slot
+	for _, seq := range s.segments {
+		last := seq == s.segments[len(s.segments)-1]
+		size, err := s.scan(seq, last)
+		if err != nil {
+			return nil, err
+		}
+		s.sizes[seq] = size
+		s.total += size
+	}
+	s.read = s.acked
+
+	return s, nil
+}
+
+// Append spools an update and syncs it to disk
+func (s *Spool) Append(update *pb.SubscribeUpdate) error {
+	payload, err := proto.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("error encoding update: %v", err)
+	}
+	record := make([]byte, recordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeader:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.total+int64(len(record)) > s.maxBytes {
+		return ErrFull
+	}
+	if err := s.rotate(int64(len(record))); err != nil {
+		return err
+	}
+
+	seq := s.segments[len(s.segments)-1]
+	if _, err := s.writer.Write(record); err != nil {
+		return fmt.Errorf("error writing spool segment: %v", err)
+	}
+	if err := s.writer.Sync(); err != nil {
+		return fmt.Errorf("error syncing spool segment: %v", err)
+	}
+	s.sizes[seq] += int64(len(record))
+	s.total += int64(len(record))
+	s.pending++
+	if block := update.GetBlock(); block != nil {
+		s.lastSlot = max(s.lastSlot, block.Slot)
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Next returns the oldest update not yet returned, and the position to
+// acknowledge once it is written. It reports false when nothing is pending.
+func (s *Spool) Next() (*pb.SubscribeUpdate, Position, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.pending > 0 {
+		if s.read.Offset >= s.sizes[s.read.Segment] {
+			// Move on to the following segment
+			next, ok := s.after(s.read.Segment)
+			if !ok {
+				return nil, Position{}, false, fmt.Errorf("spool lost track of segment after %d", s.read.Segment)
+			}
+			s.read = Position{Segment: next}
+			continue
+		}
+
+		payload, err := s.readRecord(s.read)
+		if err != nil {
+			return nil, Position{}, false, err
+		}
+		update := &pb.SubscribeUpdate{}
+		if err := proto.Unmarshal(payload, update); err != nil {
+			return nil, Position{}, false, fmt.Errorf("error decoding spooled update: %v", err)
+		}
+
+		s.read.Offset += int64(recordHeader + len(payload))
+		s.pending--
+		return update, s.read, true, nil
+	}
+	return nil, Position{}, false, nil
+}
+
+// Ack records that every update up to pos is in the database and removes
+// segments that are no longer needed
+func (s *Spool) Ack(pos Position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.acked = pos
+	if err := s.saveCursor(); err != nil {
+		return err
+	}
+
+	for len(s.segments) > 1 && s.segments[0] < pos.Segment {
+		seq := s.segments[0]
+		if err := os.Remove(s.path(seq)); err != nil {
+			return fmt.Errorf("error removing spool segment: %v", err)
+		}
+		s.total -= s.sizes[seq]
+		delete(s.sizes, seq)
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+// Pending returns the number of spooled updates not yet returned by Next
+func (s *Spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Size returns the bytes the spool occupies on disk
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// LastSlot returns the highest slot of a block ever spooled since the spool
+// was opened or still on disk
+func (s *Spool) LastSlot() (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSlot, s.lastSlot > 0
+}
+
+// Notify returns a channel signalled when an update is appended
+func (s *Spool) Notify() <-chan struct{} {
+	return s.notify
+}
+
+// Close closes the segment being written
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.Close()
+	s.writer = nil
+	return err
+}
+
+// rotate makes sure a segment with room for size more bytes is open for
+// appending. s.mu must be held.
+func (s *Spool) rotate(size int64) error {
+	if len(s.segments) > 0 {
+		seq := s.segments[len(s.segments)-1]
+		if s.sizes[seq] == 0 || s.sizes[seq]+size <= s.segmentSize {
+			if s.writer != nil {
+				return nil
+			}
+			file, err := os.OpenFile(s.path(seq), os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return fmt.Errorf("error opening spool segment: %v", err)
+			}
+			s.writer = file
+			return nil
+		}
+	}
+
+	var seq uint64
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1] + 1
+	}
+	file, err := os.OpenFile(s.path(seq), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating spool segment: %v", err)
+	}
+	if s.writer != nil {
+		s.writer.Close()
+	}
+	s.writer = file
+	s.segments = append(s.segments, seq)
+	s.sizes[seq] = 0
+	if len(s.segments) == 1 {
+		s.read = Position{Segment: seq}
+		s.acked = s.read
+	}
+	return nil
+}
+
+// scan validates the records of a segment, counting those after the cursor
+// and tracking the last block slot. A torn record at the end of the last
+// segment is truncated. It returns the segment's valid size.
+func (s *Spool) scan(seq uint64, last bool) (int64, error) {
+	file, err := os.Open(s.path(seq))
+	if err != nil {
+		return 0, fmt.Errorf("error opening spool segment: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("error reading spool segment: %v", err)
+	}
+
+	var offset int64
+	header := make([]byte, recordHeader)
+	for offset+recordHeader <= info.Size() {
+		if _, err := file.ReadAt(header, offset); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if offset+recordHeader+length > info.Size() {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := file.ReadAt(payload, offset+recordHeader); err != nil && !errors.Is(err, io.EOF) {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+
+		update := &pb.SubscribeUpdate{}
+		if err := proto.Unmarshal(payload, update); err == nil && update.GetBlock() != nil {
+			s.lastSlot = max(s.lastSlot, update.GetBlock().Slot)
+		}
+		if seq > s.acked.Segment || (seq == s.acked.Segment && offset >= s.acked.Offset) {
+			s.pending++
+		}
+		offset += recordHeader + length
+	}
+
+	if info.Size() != offset {
+		if !last {
+			return 0, fmt.Errorf("spool segment %d is corrupt at offset %d", seq, offset)
+		}
+		if err := os.Truncate(s.path(seq), offset); err != nil {
+			return 0, fmt.Errorf("error truncating torn spool record: %v", err)
+		}
+	}
+	return offset, nil
+}
+
+// readRecord reads the payload of the record at pos. s.mu must be held.
+func (s *Spool) readRecord(pos Position) ([]byte, error) {
+	file, err := os.Open(s.path(pos.Segment))
+	if err != nil {
+		return nil, fmt.Errorf("error opening spool segment: %v", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, recordHeader)
+	if _, err := file.ReadAt(header, pos.Offset); err != nil {
+		return nil, fmt.Errorf("error reading spool record: %v", err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := file.ReadAt(payload, pos.Offset+recordHeader); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading spool record: %v", err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("spool record at %d:%d is corrupt", pos.Segment, pos.Offset)
+	}
+	return payload, nil
+}
+
+// after returns the segment following seq. s.mu must be held.
+func (s *Spool) after(seq uint64) (uint64, bool) {
+	for _, next := range s.segments {
+		if next > seq {
+			return next, true
+		}
+	}
+	return 0, false
+}
+
+// loadCursor reads the acknowledged position, defaulting to the start of the
+// oldest segment
+func (s *Spool) loadCursor() error {
+	if len(s.segments) > 0 {
+		s.acked = Position{Segment: s.segments[0]}
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading spool cursor: %v", err)
+	}
+
+	var pos Position
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.Segment, &pos.Offset); err != nil {
+		return fmt.Errorf("error parsing spool cursor: %v", err)
+	}
+	if len(s.segments) > 0 && pos.Segment >= s.segments[0] {
+		s.acked = pos
+	}
+	return nil
+}
+
+// saveCursor atomically replaces the cursor file. s.mu must be held.
+func (s *Spool) saveCursor() error {
+	tmp := filepath.Join(s.dir, cursorFile+".tmp")
+	data := fmt.Sprintf("%d %d\n", s.acked.Segment, s.acked.Offset)
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		return fmt.Errorf("error writing spool cursor: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, cursorFile)); err != nil {
+		return fmt.Errorf("error replacing spool cursor: %v", err)
+	}
+	return nil
+}
+
+// path returns the file name of a segment
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
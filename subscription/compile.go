@@ -0,0 +1,251 @@
+package subscription
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+// ParseCommitment maps a commitment name to its level, defaulting to finalized
+func ParseCommitment(name string) (pb.CommitmentLevel, error) {
+	if name == "" {
+		return pb.CommitmentLevel_FINALIZED, nil
+	}
+	level, ok := pb.CommitmentLevel_value[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown commitment level %q", name)
+	}
+	switch commitment := pb.CommitmentLevel(level); commitment {
+	case pb.CommitmentLevel_PROCESSED, pb.CommitmentLevel_CONFIRMED, pb.CommitmentLevel_FINALIZED:
+		return commitment, nil
+	default:
+		return 0, fmt.Errorf("commitment level %q cannot be subscribed to", name)
+	}
+}
+
+// Validate checks that the config describes a request Geyser will accept
+func (c *Config) Validate() error {
+	if _, err := ParseCommitment(c.Commitment); err != nil {
+		return err
+	}
+
+	// Geyser requires slices in order and without overlap
+	for i, slice := range c.AccountsDataSlice {
+		if slice.Length == 0 {
+			return fmt.Errorf("accounts_data_slice %d has zero length", i)
+		}
+		if i > 0 {
+			prev := c.AccountsDataSlice[i-1]
+			if slice.Offset < prev.Offset+prev.Length {
+				return fmt.Errorf("accounts_data_slice %d overlaps or precedes the previous slice", i)
+			}
+		}
+	}
+
+	for name, filter := range c.Transactions {
+		if err := validateName(name); err != nil {
+			return fmt.Errorf("transactions filter: %v", err)
+		}
+		if filter.Signature != nil {
+			if err := validateSignature(*filter.Signature); err != nil {
+				return fmt.Errorf("transactions filter %q: %v", name, err)
+			}
+		}
+		for _, list := range [][]string{filter.AccountInclude, filter.AccountExclude, filter.AccountRequired} {
+			if err := validatePubkeys(list); err != nil {
+				return fmt.Errorf("transactions filter %q: %v", name, err)
+			}
+		}
+	}
+
+	for name, filter := range c.Accounts {
+		if err := validateName(name); err != nil {
+			return fmt.Errorf("accounts filter: %v", err)
+		}
+		if filter.IsEmpty() {
+			return fmt.Errorf("accounts filter %q has no criteria", name)
+		}
+		if err := validatePubkeys(filter.Pubkeys); err != nil {
+			return fmt.Errorf("accounts filter %q: %v", name, err)
+		}
+		if err := validatePubkeys(filter.Owners); err != nil {
+			return fmt.Errorf("accounts filter %q: %v", name, err)
+		}
+		for _, m := range filter.Memcmp {
+			if _, err := base58.Decode(m.Base58); err != nil {
+				return fmt.Errorf("accounts filter %q: invalid memcmp data %q: %v", name, m.Base58, err)
+			}
+		}
+		if _, err := filter.ToProto(); err != nil {
+			return fmt.Errorf("accounts filter %q: %v", name, err)
+		}
+	}
+
+	for name, filter := range c.Blocks {
+		if err := validateName(name); err != nil {
+			return fmt.Errorf("blocks filter: %v", err)
+		}
+		if err := validatePubkeys(filter.AccountInclude); err != nil {
+			return fmt.Errorf("blocks filter %q: %v", name, err)
+		}
+	}
+
+	for _, names := range []struct {
+		kind  string
+		names []string
+	}{
+		{"blocks_meta", keys(c.BlocksMeta)},
+		{"entry", keys(c.Entry)},
+		{"slots", keys(c.Slots)},
+	} {
+		for _, name := range names.names {
+			if err := validateName(name); err != nil {
+				return fmt.Errorf("%s filter: %v", names.kind, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Restrict rejects filters of any kind outside allowed, so updates a consumer
+// cannot handle are never subscribed to and silently dropped
+func (c *Config) Restrict(allowed ...string) error {
+	for _, declared := range []struct {
+		kind  string
+		count int
+	}{
+		{"transactions", len(c.Transactions)},
+		{"accounts", len(c.Accounts)},
+		{"blocks", len(c.Blocks)},
+		{"blocks_meta", len(c.BlocksMeta)},
+		{"entry", len(c.Entry)},
+		{"slots", len(c.Slots)},
+	} {
+		if declared.count > 0 && !slices.Contains(allowed, declared.kind) {
+			return fmt.Errorf("%s filters are not supported here, expected only %s", declared.kind, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// Compile turns the config into a subscribe request
+func (c *Config) Compile() (*pb.SubscribeRequest, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Validated above
+	commitment, _ := ParseCommitment(c.Commitment)
+	req := &pb.SubscribeRequest{
+		Commitment: &commitment,
+		FromSlot:   c.FromSlot,
+	}
+
+	for _, slice := range c.AccountsDataSlice {
+		req.AccountsDataSlice = append(req.AccountsDataSlice, &pb.SubscribeRequestAccountsDataSlice{
+			Offset: slice.Offset,
+			Length: slice.Length,
+		})
+	}
+
+	if len(c.Transactions) > 0 {
+		req.Transactions = make(map[string]*pb.SubscribeRequestFilterTransactions)
+		for name, filter := range c.Transactions {
+			req.Transactions[name] = &pb.SubscribeRequestFilterTransactions{
+				Vote:            filter.Vote,
+				Failed:          filter.Failed,
+				Signature:       filter.Signature,
+				AccountInclude:  filter.AccountInclude,
+				AccountExclude:  filter.AccountExclude,
+				AccountRequired: filter.AccountRequired,
+			}
+		}
+	}
+
+	if len(c.Accounts) > 0 {
+		req.Accounts = make(map[string]*pb.SubscribeRequestFilterAccounts)
+		for name, filter := range c.Accounts {
+			// Validated above
+			req.Accounts[name], _ = filter.ToProto()
+		}
+	}
+
+	if len(c.Blocks) > 0 {
+		req.Blocks = make(map[string]*pb.SubscribeRequestFilterBlocks)
+		for name, filter := range c.Blocks {
+			req.Blocks[name] = &pb.SubscribeRequestFilterBlocks{
+				AccountInclude:      filter.AccountInclude,
+				IncludeTransactions: filter.IncludeTransactions,
+				IncludeAccounts:     filter.IncludeAccounts,
+				IncludeEntries:      filter.IncludeEntries,
+			}
+		}
+	}
+
+	if len(c.BlocksMeta) > 0 {
+		req.BlocksMeta = make(map[string]*pb.SubscribeRequestFilterBlocksMeta)
+		for name := range c.BlocksMeta {
+			req.BlocksMeta[name] = &pb.SubscribeRequestFilterBlocksMeta{}
+		}
+	}
+
+	if len(c.Entry) > 0 {
+		req.Entry = make(map[string]*pb.SubscribeRequestFilterEntry)
+		for name := range c.Entry {
+			req.Entry[name] = &pb.SubscribeRequestFilterEntry{}
+		}
+	}
+
+	if len(c.Slots) > 0 {
+		req.Slots = make(map[string]*pb.SubscribeRequestFilterSlots)
+		for name, filter := range c.Slots {
+			req.Slots[name] = &pb.SubscribeRequestFilterSlots{FilterByCommitment: filter.FilterByCommitment}
+		}
+	}
+
+	return req, nil
+}
+
+// validateName rejects empty filter names
+func validateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("filter name must not be empty")
+	}
+	return nil
+}
+
+// validatePubkeys checks that every entry is a base58 encoded 32 byte key
+func validatePubkeys(pubkeys []string) error {
+	for _, pubkey := range pubkeys {
+		decoded, err := base58.Decode(pubkey)
+		if err != nil || len(decoded) != 32 {
+			return fmt.Errorf("invalid pubkey %q", pubkey)
+		}
+	}
+	return nil
+}
+
+// validateSignature checks that sig is a base58 encoded 64 byte signature
+func validateSignature(sig string) error {
+	decoded, err := base58.Decode(sig)
+	if err != nil || len(decoded) != 64 {
+		return fmt.Errorf("invalid signature %q", sig)
+	}
+	return nil
+}
+
+// keys returns the sorted names of a filter map
+func keys[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,144 @@
+package subscription
+
+import (
+	"strings"
+	"testing"
+
+	pb "goblockstore/proto"
+
+	"github.com/mr-tron/base58"
+)
+
+const testPubkey = "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA"
+
+func TestParseCommitment(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    pb.CommitmentLevel
+		wantErr bool
+	}{
+		{"", pb.CommitmentLevel_FINALIZED, false},
+		{"processed", pb.CommitmentLevel_PROCESSED, false},
+		{"Confirmed", pb.CommitmentLevel_CONFIRMED, false},
+		{"FINALIZED", pb.CommitmentLevel_FINALIZED, false},
+		{"recent", 0, true},
+		{"first_shred_received", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCommitment(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCommitment(%q): error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCommitment(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseValidates(t *testing.T) {
+	signature := base58.Encode(make([]byte, 64))
+	tests := []struct {
+		name   string
+		config string
+		err    string // substring of the expected error, empty for none
+	}{
+		{"empty", `{}`, ""},
+		{"unknown field", `{"commitmnet": "confirmed"}`, "unknown field"},
+		{"bad commitment", `{"commitment": "recent"}`, "unknown commitment"},
+		{"slices in order", `{"accounts_data_slice": [{"offset": 0, "length": 8}, {"offset": 8, "length": 8}]}`, ""},
+		{"zero length slice", `{"accounts_data_slice": [{"offset": 0, "length": 0}]}`, "zero length"},
+		{"overlapping slices", `{"accounts_data_slice": [{"offset": 0, "length": 8}, {"offset": 4, "length": 8}]}`, "overlaps"},
+		{"transaction signature", `{"transactions": {"tx": {"signature": "` + signature + `"}}}`, ""},
+		{"short signature", `{"transactions": {"tx": {"signature": "` + testPubkey + `"}}}`, "invalid signature"},
+		{"bad account include", `{"transactions": {"tx": {"account_include": ["nope"]}}}`, "invalid pubkey"},
+		{"empty filter name", `{"transactions": {" ": {}}}`, "name must not be empty"},
+		{"accounts without criteria", `{"accounts": {"all": {}}}`, "no criteria"},
+		{"accounts by owner", `{"accounts": {"tokens": {"owners": ["` + testPubkey + `"]}}}`, ""},
+		{"bad memcmp", `{"accounts": {"tokens": {"memcmp": [{"offset": 0, "base58": "0OIl"}]}}}`, "invalid memcmp"},
+		{"bad block account", `{"blocks": {"b": {"account_include": ["nope"]}}}`, "invalid pubkey"},
+		{"empty slots name", `{"slots": {"": {}}}`, "slots filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Parse: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse: %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		allowed []string
+		err     string // substring of the expected error, empty for none
+	}{
+		{"empty", `{}`, nil, ""},
+		{"allowed kinds", `{"blocks": {"b": {}}, "slots": {"s": {}}}`, []string{"blocks", "slots"}, ""},
+		{"transactions in blocks mode", `{"transactions": {"tx": {}}}`, []string{"blocks", "slots"}, "transactions filters"},
+		{"entry", `{"entry": {"e": {}}}`, []string{"blocks_meta", "slots"}, "entry filters"},
+		{"commitment only", `{"commitment": "confirmed", "accounts_data_slice": [{"offset": 0, "length": 8}]}`, []string{"slots"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := Parse([]byte(tt.config))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			err = config.Restrict(tt.allowed...)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Restrict: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Restrict: %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompileExample(t *testing.T) {
+	config, err := Load("example.yaml")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	req, err := config.Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	if req.GetCommitment() != pb.CommitmentLevel_CONFIRMED {
+		t.Errorf("commitment %v, want CONFIRMED", req.GetCommitment())
+	}
+	if slots := req.Slots["slots"]; slots == nil || slots.FilterByCommitment == nil || slots.GetFilterByCommitment() {
+		t.Errorf("slots filter %v, want filter_by_commitment false", slots)
+	}
+	if err := config.Restrict("blocks", "slots", "accounts"); err != nil {
+		t.Errorf("Restrict to blocks mode: %v", err)
+	}
+	blocks := req.Blocks["blocks"]
+	if !blocks.GetIncludeTransactions() || blocks.GetIncludeAccounts() || !blocks.GetIncludeEntries() {
+		t.Errorf("blocks filter %v", blocks)
+	}
+	accounts := req.Accounts["usdc_token_accounts"]
+	if accounts == nil || len(accounts.Owner) != 1 || accounts.Owner[0] != testPubkey {
+		t.Errorf("accounts filter %v", accounts)
+	}
+	if len(req.AccountsDataSlice) != 1 || req.AccountsDataSlice[0].Offset != 32 || req.AccountsDataSlice[0].Length != 40 {
+		t.Errorf("data slices %v", req.AccountsDataSlice)
+	}
+	if req.Transactions != nil || req.BlocksMeta != nil || req.Entry != nil {
+		t.Errorf("unconfigured filters compiled: %v", req)
+	}
+}
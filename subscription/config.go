@@ -0,0 +1,113 @@
+package subscription
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"goblockstore/accounts"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config declares the filters of a Geyser subscription. Each map holds named
+// filters of one kind; an update is delivered when any filter of its kind matches.
+type Config struct {
+	// Commitment is processed, confirmed or finalized
+	Commitment string `json:"commitment,omitempty"`
+	// FromSlot is where to start when nothing has been ingested yet
+	FromSlot *uint64 `json:"from_slot,omitempty"`
+	// AccountsDataSlice limits account data to the given ranges
+	AccountsDataSlice []DataSlice `json:"accounts_data_slice,omitempty"`
+
+	Transactions map[string]TransactionFilter `json:"transactions,omitempty"`
+	Accounts     map[string]accounts.Filter   `json:"accounts,omitempty"`
+	Blocks       map[string]BlockFilter       `json:"blocks,omitempty"`
+	BlocksMeta   map[string]struct{}          `json:"blocks_meta,omitempty"`
+	Entry        map[string]struct{}          `json:"entry,omitempty"`
+	Slots        map[string]SlotFilter        `json:"slots,omitempty"`
+}
+
+// DataSlice is a byte range of account data
+type DataSlice struct {
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+}
+
+// TransactionFilter selects transactions. Unset booleans match both values.
+type TransactionFilter struct {
+	Vote            *bool    `json:"vote,omitempty"`
+	Failed          *bool    `json:"failed,omitempty"`
+	Signature       *string  `json:"signature,omitempty"`
+	AccountInclude  []string `json:"account_include,omitempty"`
+	AccountExclude  []string `json:"account_exclude,omitempty"`
+	AccountRequired []string `json:"account_required,omitempty"`
+}
+
+// BlockFilter selects blocks and what they carry
+type BlockFilter struct {
+	AccountInclude      []string `json:"account_include,omitempty"`
+	IncludeTransactions *bool    `json:"include_transactions,omitempty"`
+	IncludeAccounts     *bool    `json:"include_accounts,omitempty"`
+	IncludeEntries      *bool    `json:"include_entries,omitempty"`
+}
+
+// SlotFilter selects slot status updates
+type SlotFilter struct {
+	// FilterByCommitment only delivers slots reaching the subscribed commitment
+	FilterByCommitment *bool `json:"filter_by_commitment,omitempty"`
+}
+
+// Load reads a config file. Files ending in .yaml or .yml are parsed as
+// YAML, anything else as JSON. Unknown fields are rejected.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading subscription config: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing subscription config %s: %v", path, err)
+		}
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing subscription config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Parse decodes and validates a JSON config
+func Parse(data []byte) (*Config, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// yamlToJSON converts a YAML document to JSON so a single set of field
+// names and the same strict decoding apply to both formats
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		document = map[string]any{}
+	}
+	return json.Marshal(document)
+}
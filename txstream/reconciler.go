@@ -0,0 +1,132 @@
+package txstream
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"goblockstore/parser"
+)
+
+// DefaultGrace is how many slots past a pending slot a block header may
+// arrive before the slot's transactions are considered never landed
+const DefaultGrace = 150
+
+// Reconciler stores transactions as soon as they are streamed and reconciles
+// them once the header of their block arrives. Transactions from a slot that
+// the canonical chain skipped are soft-deleted.
+type Reconciler struct {
+	DB    *sql.DB
+	Grace uint64
+
+	mu sync.Mutex
+	// pending maps a slot to the number of provisional transactions saved for it
+	pending map[uint64]int
+}
+
+// NewReconciler creates a reconciler writing to db
+func NewReconciler(db *sql.DB) *Reconciler {
+	return &Reconciler{
+		DB:      db,
+		Grace:   DefaultGrace,
+		pending: make(map[uint64]int),
+	}
+}
+
+// Resume picks up the provisional transactions left by a previous run.
+// Those whose block was saved are reconciled, those from slots more than
+// Grace behind lastSlot never landed and are dropped, and the rest wait for
+// their block as usual.
+func (r *Reconciler) Resume(lastSlot uint64) error {
+	updated, err := parser.ReconcileStored(r.DB)
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		log.Printf("Reconciled %d streamed transactions with stored blocks", updated)
+	}
+
+	slots, err := parser.ProvisionalSlots(r.DB)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	var dropped []uint64
+	for slot, count := range slots {
+		if slot+r.Grace < lastSlot {
+			dropped = append(dropped, slot)
+			continue
+		}
+		r.pending[slot] += count
+	}
+	r.mu.Unlock()
+
+	return r.drop(dropped)
+}
+
+// OnTransaction saves a streamed transaction, parsed by
+// parser.ParseTransaction, ahead of its block
+func (r *Reconciler) OnTransaction(parsed *parser.ParsedBlock) error {
+	slot := parsed.Block.Slot
+	saved, err := parser.SaveProvisionalTransactions(r.DB, parsed)
+	if err != nil {
+		return fmt.Errorf("failed to save transaction at slot %d: %v", slot, err)
+	}
+	if saved {
+		r.mu.Lock()
+		r.pending[slot]++
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// OnBlock reconciles the transactions of a block whose header was saved.
+// Pending slots between the block and its parent were skipped by the
+// canonical chain, and slots older than Grace are assumed to be as well, so
+// their transactions are dropped.
+func (r *Reconciler) OnBlock(block *parser.Block) error {
+	updated, err := parser.ReconcileSlot(r.DB, block)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	dropped := r.settle(block.ParentSlot, block.Slot)
+	r.mu.Unlock()
+
+	if updated > 0 {
+		log.Printf("Reconciled %d streamed transactions with block %d", updated, block.Slot)
+	}
+	return r.drop(dropped)
+}
+
+// settle forgets the pending slot of a block and returns the pending slots
+// it shows never landed. r.mu must be held.
+func (r *Reconciler) settle(parent, slot uint64) []uint64 {
+	delete(r.pending, slot)
+	var dropped []uint64
+	for pending := range r.pending {
+		if (pending > parent && pending < slot) || pending+r.Grace < slot {
+			dropped = append(dropped, pending)
+			delete(r.pending, pending)
+		}
+	}
+	return dropped
+}
+
+// drop soft-deletes the provisional transactions of slots that never landed
+func (r *Reconciler) drop(dropped []uint64) error {
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	sort.Slice(dropped, func(a, b int) bool { return dropped[a] < dropped[b] })
+	log.Printf("Dropping streamed transactions from slots %v that never landed", dropped)
+	if err := parser.RollbackSlots(r.DB, dropped, ""); err != nil {
+		return fmt.Errorf("error dropping transactions of skipped slots: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package txstream
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestReconcilerSettle(t *testing.T) {
+	tests := []struct {
+		name      string
+		pending   []uint64
+		parent    uint64
+		slot      uint64
+		dropped   []uint64
+		remaining []uint64
+	}{
+		{"block reconciled", []uint64{100}, 99, 100, nil, nil},
+		{"later slots wait", []uint64{100, 101, 102}, 99, 100, nil, []uint64{101, 102}},
+		{"skipped slots dropped", []uint64{100, 101, 102}, 100, 103, []uint64{101, 102}, []uint64{100}},
+		{"parent kept", []uint64{100, 103}, 100, 102, nil, []uint64{100, 103}},
+		{"within grace kept", []uint64{50}, 199, 200, nil, []uint64{50}},
+		{"past grace dropped", []uint64{49}, 199, 200, []uint64{49}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReconciler(nil)
+			for _, slot := range tt.pending {
+				r.pending[slot] = 1
+			}
+
+			dropped := r.settle(tt.parent, tt.slot)
+			sort.Slice(dropped, func(a, b int) bool { return dropped[a] < dropped[b] })
+			if !equalSlots(dropped, tt.dropped) {
+				t.Errorf("dropped %v, want %v", dropped, tt.dropped)
+			}
+			var remaining []uint64
+			for slot := range r.pending {
+				remaining = append(remaining, slot)
+			}
+			sort.Slice(remaining, func(a, b int) bool { return remaining[a] < remaining[b] })
+			if !equalSlots(remaining, tt.remaining) {
+				t.Errorf("remaining %v, want %v", remaining, tt.remaining)
+			}
+		})
+	}
+}
+
+// equalSlots compares two sorted slot lists
+func equalSlots(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}